@@ -80,7 +80,7 @@ func driveClient() (*http.Client, error) {
 		Source: ts,
 		Base:   http.DefaultTransport,
 	}
-	hc := &http.Client{Transport: t}
+	hc := &http.Client{Transport: t, Timeout: fetchTimeout}
 	clients[providerGoogle] = hc
 	return hc, nil
 }
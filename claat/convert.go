@@ -0,0 +1,104 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/googlecodelabs/tools/claat/render"
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+// sourceFilename is the Markdown source written by the convert command,
+// relative to the codelab dir.
+const sourceFilename = "codelab.md"
+
+// cmdConvert is the "claat convert ..." subcommand.
+func cmdConvert() {
+	if flag.NArg() == 0 {
+		fatalf("Need at least one source. Try '-h' for options.")
+	}
+	storeAll(unique(flag.Args()), convertDirs, storeSource)
+}
+
+// convertDirs returns the codelab dir storeSource writes codelab meta to,
+// or none if *output is stdout.
+func convertDirs(meta *types.Meta) ([]string, error) {
+	if isStdout(*output) {
+		return nil, nil
+	}
+	dir, err := codelabDir(*output, meta)
+	if err != nil {
+		return nil, err
+	}
+	return []string{dir}, nil
+}
+
+// convertCodelab fetches and parses codelab src, and stores it on disk
+// as a Markdown source of the md parser, in a dir ancestored by *output.
+// Codelab images are downloaded into the same dir, next to the Markdown file.
+// The dir is written with writeStaged, so that it is left intact on failure.
+//
+// Imported fragments are included in the result, so that it does not
+// depend on the original source.
+//
+// As with exportCodelab, a *output value of "-" results in
+// the Markdown being printed to stdout, without any images.
+func convertCodelab(src string) (*types.Meta, error) {
+	clab, err := slurpCodelab(src)
+	if err != nil {
+		return nil, err
+	}
+	return storeSource(src, clab)
+}
+
+// storeSource stores codelab clab, fetched and parsed from src,
+// the way convertCodelab does.
+func storeSource(src string, clab *codelab) (*types.Meta, error) {
+	var err error
+	meta := &clab.Meta
+	if isStdout(*output) {
+		return meta, render.WriteMDSource(os.Stdout, clab.Codelab)
+	}
+
+	var client *http.Client // need for downloadImages
+	if clab.typ == srcGoogleDoc {
+		client, err = driveClient()
+		if err != nil {
+			return nil, err
+		}
+	}
+	dir, err := codelabDir(*output, meta)
+	if err != nil {
+		return nil, err
+	}
+	return meta, writeStaged(dir, func(staged string) error {
+		if _, err := slurpImages(client, src, filepath.Join(staged, imgDirname), clab.Steps); err != nil {
+			return err
+		}
+		f, err := os.Create(filepath.Join(staged, sourceFilename))
+		if err != nil {
+			return err
+		}
+		if err := render.WriteMDSource(f, clab.Codelab); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/googlecodelabs/tools/claat/parser"
+	"github.com/googlecodelabs/tools/claat/render"
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+const convertDoc = `<html><head><style>
+.meta { color: #b7b7b7 }
+.code { font-family: "Courier New" }
+.bold { font-weight: bold }
+.ibox { background-color: #d9ead3 }
+.survey { background-color: #cfe2f3 }
+</style></head><body>
+<p class="title"><span>Test Codelab</span></p>
+<table><tbody><tr><td><p>Summary</p></td><td><p>A summary.</p></td></tr>
+<tr><td><p>Environments</p></td><td><p>web, kiosk</p></td></tr></tbody></table>
+<h1><span>Overview</span></h1>
+<p><span class="meta">Duration: 10:00</span></p>
+<p>Some *stars* and <span class="bold">bold</span>.</p>
+<ul><li><span>One</span></li><li><span><a href="http://example.com">Link</a></span></li></ul>
+<p><img src="https://example.com/diagram.png" alt="Diagram"></p>
+<table><tbody><tr><td><p><span class="code">func() {<br>}</span></p></td></tr></tbody></table>
+<table><tbody><tr><td class="ibox"><p><span>positive box.</span></p></td></tr></tbody></table>
+<h1><span>Second</span></h1>
+<p><span class="meta">Duration: 5:00</span></p>
+<h3><span>Web only</span></h3>
+<p><span class="meta">Environment: web</span></p>
+<p>web text</p>
+<h3><span>Everyone</span></h3>
+<table><tbody><tr><td class="survey">
+<h4><span>How will you use it?</span></h4>
+<ul><li><span>Read it</span></li><li><span>Read and complete</span></li></ul>
+</td></tr></tbody></table>
+</body></html>`
+
+func TestConvertRoundTrip(t *testing.T) {
+	c, err := parser.Parse("gdoc", strings.NewReader(strings.Replace(convertDoc, "\n", "", -1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := render.WriteMDSource(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := parser.Parse("md", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+
+	if c2.ID != c.ID || c2.Title != c.Title || c2.Summary != c.Summary {
+		t.Errorf("meta = %+v; want %+v", c2.Meta, c.Meta)
+	}
+	if !reflect.DeepEqual(c2.Tags, c.Tags) {
+		t.Errorf("c2.Tags = %v; want %v", c2.Tags, c.Tags)
+	}
+	if len(c2.Steps) != len(c.Steps) {
+		t.Fatalf("len(c2.Steps) = %d; want %d\n%s", len(c2.Steps), len(c.Steps), buf.Bytes())
+	}
+	for i, s := range c.Steps {
+		s2 := c2.Steps[i]
+		if s2.Title != s.Title || s2.Duration != s.Duration || !reflect.DeepEqual(s2.Tags, s.Tags) {
+			t.Errorf("%d: step = %q %v %v; want %q %v %v", i, s2.Title, s2.Duration, s2.Tags, s.Title, s.Duration, s.Tags)
+		}
+		want, err := render.MD("", s.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := render.MD("", s2.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normMD(out) != normMD(want) {
+			t.Errorf("%d: step content:\n%s\nwant:\n%s", i, out, want)
+		}
+	}
+	if imgs := imageNodes(c2.Steps[0].Content.Nodes); len(imgs) != 1 || imgs[0].Alt != "Diagram" {
+		t.Errorf("images = %+v; want one with alt text Diagram", imgs)
+	}
+}
+
+func TestConvertSurveyEnv(t *testing.T) {
+	c := &types.Codelab{Meta: types.Meta{ID: "lab", Title: "Lab"}}
+	st := c.NewStep("Step")
+	survey := types.NewSurveyNode("lab-1", &types.SurveyGroup{Name: "Q?", Options: []string{"A", "B"}})
+	text := types.NewListNode(types.NewTextNode("web text"))
+	text.MutateBlock(true)
+	for _, n := range []types.Node{survey, text} {
+		n.MutateEnv([]string{"web"})
+		st.Content.Append(n)
+	}
+	var buf bytes.Buffer
+	if err := render.WriteMDSource(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := parser.Parse("md", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+	var found []string
+	for _, n := range c2.Steps[0].Content.Nodes {
+		var name string
+		switch n := n.(type) {
+		case *types.SurveyNode:
+			name = "survey"
+		case *types.TextNode:
+			name = strings.TrimSpace(n.Value)
+		}
+		if name == "" {
+			continue
+		}
+		found = append(found, name)
+		if !reflect.DeepEqual(n.Env(), []string{"web"}) {
+			t.Errorf("%s: env = %v; want [web]\n%s", name, n.Env(), buf.Bytes())
+		}
+	}
+	if want := []string{"survey", "web text"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("nodes = %q; want %q\n%s", found, want, buf.Bytes())
+	}
+}
+
+func TestConvertInfoboxCode(t *testing.T) {
+	c := &types.Codelab{Meta: types.Meta{ID: "lab", Title: "Lab"}}
+	st := c.NewStep("Step")
+	code := "func f() {\n\n}\n"
+	st.Content.Append(types.NewInfoboxNode(types.InfoboxPositive, types.NewCodeNode(code, false)))
+	var buf bytes.Buffer
+	if err := render.WriteMDSource(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := parser.Parse("md", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.Bytes())
+	}
+	var codes []string
+	for _, n := range c2.Steps[0].Content.Nodes {
+		if ib, ok := n.(*types.InfoboxNode); ok {
+			for _, cn := range codeNodes(ib.Content.Nodes) {
+				codes = append(codes, cn.Value)
+			}
+		}
+	}
+	if want := []string{code}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("infobox code = %q; want %q\n%s", codes, want, buf.Bytes())
+	}
+}
+
+// codeNodes returns code nodes of nodes, recursing into lists.
+func codeNodes(nodes []types.Node) []*types.CodeNode {
+	var codes []*types.CodeNode
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *types.CodeNode:
+			codes = append(codes, n)
+		case *types.ListNode:
+			codes = append(codes, codeNodes(n.Nodes)...)
+		}
+	}
+	return codes
+}
+
+// normMD drops blank lines, so that md renderings of equivalent steps
+// compare equal.
+func normMD(s string) string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -16,12 +16,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/googlecodelabs/tools/claat/render"
 	"github.com/googlecodelabs/tools/claat/types"
@@ -32,25 +36,77 @@ func cmdExport() {
 	if flag.NArg() == 0 {
 		fatalf("Need at least one source. Try '-h' for options.")
 	}
+	formats := parseList(*tmplout)
+	if len(formats) == 0 {
+		fatalf("Need at least one format. Try '-h' for options.")
+	}
+	if len(formats) > 1 && isStdout(*output) {
+		fatalf("Multiple formats cannot be written to stdout.")
+	}
+	envs := parseList(*expenv)
+	if len(envs) > 1 && isStdout(*output) {
+		fatalf("Multiple environments cannot be written to stdout.")
+	}
+	for _, env := range envs {
+		if _, err := render.ParseEnv(env); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	storeAll(unique(flag.Args()), exportDirs, storeCodelab)
+}
+
+// storeAll fetches and parses each of srcs, and then stores them with store,
+// concurrently, reporting the outcome of each source in srcs order.
+//
+// Sources which would be written to the same codelab dir, as returned
+// by dirs, are not stored at all. All sources are parsed before any of them
+// is stored, so that such sources are detected regardless of the order
+// in which they are fetched. See duplicateDirs.
+func storeAll(srcs []string, dirs func(*types.Meta) ([]string, error), store func(string, *codelab) (*types.Meta, error)) {
 	type result struct {
-		src  string
+		clab *codelab
 		meta *types.Meta
 		err  error
 	}
-	args := unique(flag.Args())
-	ch := make(chan *result, len(args))
-	for _, src := range args {
-		go func(src string) {
-			meta, err := exportCodelab(src)
-			ch <- &result{src, meta, err}
-		}(src)
-	}
-	for _ = range args {
-		res := <-ch
-		if res.err != nil {
-			errorf(reportErr, res.src, res.err)
+	res := make([]*result, len(srcs))
+	var wg sync.WaitGroup
+	for i, src := range srcs {
+		res[i] = &result{}
+		wg.Add(1)
+		go func(src string, r *result) {
+			defer wg.Done()
+			r.clab, r.err = slurpCodelab(src)
+		}(src, res[i])
+	}
+	wg.Wait()
+
+	srcDirs := make(map[string][]string)
+	for i, r := range res {
+		if r.err == nil {
+			srcDirs[srcs[i]], r.err = dirs(&r.clab.Meta)
+		}
+	}
+	dups := duplicateDirs(srcDirs)
+	for i, r := range res {
+		if err, ok := dups[srcs[i]]; ok && r.err == nil {
+			r.err = err
+		}
+		if r.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(src string, r *result) {
+			defer wg.Done()
+			r.meta, r.err = store(src, r.clab)
+		}(srcs[i], r)
+	}
+	wg.Wait()
+
+	for i, r := range res {
+		if r.err != nil {
+			errorf(reportErr, srcs[i], r.err)
 		} else if !isStdout(*output) {
-			printf(reportOk, res.meta.ID)
+			printf(reportOk, r.meta.ID)
 		}
 	}
 }
@@ -58,8 +114,18 @@ func cmdExport() {
 // exportCodelab fetches codelab src from either local disk or remote,
 // parses and stores the results on disk, in a dir ancestored by *output.
 //
-// Stored results include codelab content formatted in *tmplout, its assets
-// and metadata in JSON format.
+// Stored results include codelab content formatted in each of *tmplout formats,
+// its assets and metadata in JSON format. The source is fetched, parsed
+// and its images downloaded only once, regardless of the number of formats.
+//
+// If *expenv lists more than one environment, each of them is stored
+// in a separate dir, named according to *envdir. See variantDir for details.
+// Images are downloaded into the first environment dir and copied to the others.
+//
+// Each dir is written to a staging dir and committed only if all of them
+// have been written, so that they are left intact if the export fails.
+// Files of a dir which the export does not write are kept, except for
+// images, which are replaced. See carryOver.
 //
 // There's a special case where basedir has a value of "-", in which
 // nothing is stored on disk and the only output, codelab formatted content,
@@ -69,6 +135,13 @@ func exportCodelab(src string) (*types.Meta, error) {
 	if err != nil {
 		return nil, err
 	}
+	return storeCodelab(src, clab)
+}
+
+// storeCodelab stores codelab clab, retrieved from src with slurpCodelab,
+// the same way exportCodelab does.
+func storeCodelab(src string, clab *codelab) (*types.Meta, error) {
+	var err error
 	var client *http.Client // need for downloadImages
 	if clab.typ == srcGoogleDoc {
 		client, err = driveClient()
@@ -80,30 +153,142 @@ func exportCodelab(src string) (*types.Meta, error) {
 	// codelab export context
 	lastmod := types.ContextTime(clab.mod)
 	meta := &clab.Meta
+	hash, err := codelabHash(clab.Codelab)
+	if err != nil {
+		return nil, err
+	}
+	formats := parseList(*tmplout)
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no output format")
+	}
+	envs, layout := exportEnvs()
 	ctx := &types.Context{
 		Source:  src,
-		Env:     *expenv,
-		Format:  *tmplout,
+		Format:  formats[0],
+		Formats: formats,
+		EnvDir:  layout,
 		Prefix:  *prefix,
 		MainGA:  *globalGA,
 		Updated: &lastmod,
+		Hash:    hash,
+		Imports: importURLs(clab.Steps),
+	}
+	if len(envs) > 1 {
+		ctx.Envs = envs
 	}
 
-	dir := *output // output dir or stdout
-	if !isStdout(dir) {
-		dir = codelabDir(dir, meta)
-		// download or copy codelab assets to disk, and rewrite image URLs
-		mdir := filepath.Join(dir, imgDirname)
-		if _, err := slurpImages(client, src, mdir, clab.Steps); err != nil {
+	if isStdout(*output) {
+		for _, env := range envs {
+			ctx.Env = env
+			if err := writeCodelab(*output, clab.Codelab, ctx); err != nil {
+				return nil, err
+			}
+		}
+		return meta, nil
+	}
+
+	// Write codelab, its assets and metadata to disk, all or nothing:
+	// every environment is written to a staging dir first,
+	// and none of them is committed unless all have been written.
+	// Commits are separate renames though, see commitDir.
+	dirs := make([]string, len(envs))
+	staged := make([]string, len(envs))
+	defer func() {
+		// no-op for committed dirs
+		for _, s := range staged {
+			if s != "" {
+				os.RemoveAll(s)
+			}
+		}
+	}()
+	var imgdir string // where images have been downloaded to
+	var imgmap map[string]string
+	for i, env := range envs {
+		ctx.Env = env
+		if dirs[i], err = variantDir(*output, meta, env, layout); err != nil {
+			return nil, err
+		}
+		if staged[i], err = stageDir(dirs[i]); err != nil {
+			return nil, err
+		}
+		mdir := filepath.Join(staged[i], imgDirname)
+		if imgdir == "" {
+			// download or copy codelab assets to disk, and rewrite image URLs
+			if imgmap, err = slurpImages(client, src, mdir, clab.Steps); err != nil {
+				return nil, err
+			}
+			imgdir = mdir
+			ctx.Images = imgmap
+		} else if err := copyImages(imgdir, mdir, imgmap); err != nil {
 			return nil, err
 		}
+		if err := writeCodelab(staged[i], clab.Codelab, ctx); err != nil {
+			return nil, err
+		}
+		if err := carryOver(staged[i], dirs[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range envs {
+		if err := commitDir(staged[i], dirs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// exportEnvs returns environments of *expenv, or a single empty one
+// if none is specified, and the layout of their dirs, see variantDir.
+func exportEnvs() (envs []string, layout string) {
+	envs = parseList(*expenv)
+	if len(envs) == 0 {
+		envs = []string{""}
+	}
+	layout = *envdir
+	if layout == "" && len(envs) > 1 {
+		layout = defaultEnvDir
+	}
+	return envs, layout
+}
+
+// exportDirs returns codelab dirs storeCodelab writes codelab meta to,
+// one for each environment, or none if *output is stdout.
+func exportDirs(meta *types.Meta) ([]string, error) {
+	if isStdout(*output) {
+		return nil, nil
+	}
+	envs, layout := exportEnvs()
+	dirs := make([]string, len(envs))
+	for i, env := range envs {
+		var err error
+		if dirs[i], err = variantDir(*output, meta, env, layout); err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// copyImages copies image files of imgmap, as returned by slurpImages,
+// from src to dst dir.
+func copyImages(src, dst string, imgmap map[string]string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for name := range imgmap {
+		if err := copyFile(filepath.Join(dst, name), filepath.Join(src, name), 0644); err != nil {
+			return err
+		}
 	}
-	// write codelab and its metadata to disk
-	return meta, writeCodelab(dir, clab.Codelab, ctx)
+	return nil
 }
 
-// writeCodelab stores codelab main content in ctx.Format and its metadata
-// in JSON format on disk.
+// writeCodelab stores codelab main content in each of ctx.Formats,
+// or ctx.Format if the former is empty, and its metadata in JSON format on disk.
+//
+// The first format is written to dir. So are the others, unless their main
+// file has already been written by a previous format, as with "html" and "offline"
+// which both produce an index.html. In that case, the format is written
+// to a subdirectory named after it, with relative image and prefix URLs adjusted.
 func writeCodelab(dir string, clab *types.Codelab, ctx *types.Context) error {
 	// output to stdout does not include metadata
 	if !isStdout(dir) {
@@ -119,7 +304,40 @@ func writeCodelab(dir string, clab *types.Codelab, ctx *types.Context) error {
 		}
 	}
 
-	// main content file(s)
+	formats := ctx.Formats
+	if len(formats) == 0 {
+		formats = []string{ctx.Format}
+	}
+	written := make(map[string]bool, len(formats)) // main file names
+	for _, f := range formats {
+		name := formatFilename(f)
+		if !written[name] || isStdout(dir) {
+			written[name] = true
+			if err := writeFormat(dir, clab, ctx, f); err != nil {
+				return err
+			}
+			continue
+		}
+		sub := *ctx
+		sub.Prefix = rebaseURL(ctx.Prefix)
+		restore := rebaseImages(clab.Steps)
+		err := writeFormat(filepath.Join(dir, formatDirname(f)), clab, &sub, f)
+		restore()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFormat stores codelab main content in the specified format,
+// in dir or stdout.
+func writeFormat(dir string, clab *types.Codelab, ctx *types.Context, format string) error {
+	if !isStdout(dir) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
 	data := &struct {
 		render.Context
 		Current *types.Step
@@ -134,17 +352,34 @@ func writeCodelab(dir string, clab *types.Codelab, ctx *types.Context) error {
 		Steps:    clab.Steps,
 		Extra:    extraVars,
 	}}
-	if ctx.Format != "offline" {
+	if format == "json" {
 		w := os.Stdout
 		if !isStdout(dir) {
-			f, err := os.Create(filepath.Join(dir, "index."+ctx.Format))
+			f, err := os.Create(filepath.Join(dir, formatFilename(format)))
 			if err != nil {
 				return err
 			}
 			w = f
 			defer f.Close()
 		}
-		return render.Execute(w, ctx.Format, data)
+		b, err := json.MarshalIndent(clab, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	}
+	if format != "offline" {
+		w := os.Stdout
+		if !isStdout(dir) {
+			f, err := os.Create(filepath.Join(dir, formatFilename(format)))
+			if err != nil {
+				return err
+			}
+			w = f
+			defer f.Close()
+		}
+		return render.Execute(w, format, data)
 	}
 	for i, step := range clab.Steps {
 		data.Current = step
@@ -153,7 +388,7 @@ func writeCodelab(dir string, clab *types.Codelab, ctx *types.Context) error {
 		data.Next = i < len(clab.Steps)-1
 		w := os.Stdout
 		if !isStdout(dir) {
-			name := "index.html"
+			name := formatFilename(format)
 			if i > 0 {
 				name = fmt.Sprintf("step-%d.html", i+1)
 			}
@@ -164,13 +399,75 @@ func writeCodelab(dir string, clab *types.Codelab, ctx *types.Context) error {
 			w = f
 			defer f.Close()
 		}
-		if err := render.Execute(w, ctx.Format, data); err != nil {
+		if err := render.Execute(w, format, data); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// formatFilename returns the main content file name of format.
+func formatFilename(format string) string {
+	switch format {
+	case "json":
+		return "index.json"
+	case "offline":
+		return "index.html"
+	}
+	return "index." + format
+}
+
+// formatDirname returns the name of a subdirectory format is written to,
+// in case its main file collides with another format. See writeCodelab.
+// Custom template formats are named after the template file.
+func formatDirname(format string) string {
+	b := filepath.Base(format)
+	return strings.TrimSuffix(b, filepath.Ext(b))
+}
+
+// parseList splits a comma-separated list of values, such as output formats
+// specified with -f, removing empty and duplicate values.
+func parseList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return unique(list)
+}
+
+// rebaseURL returns relative URL u as seen from a subdirectory.
+// Absolute URLs and paths are returned unmodified.
+func rebaseURL(u string) string {
+	if u == "" || strings.HasPrefix(u, "/") || strings.Contains(u, "://") {
+		return u
+	}
+	return "../" + u
+}
+
+// rebaseImages rewrites codelab images stored in imgDirname,
+// so that they are relative to a subdirectory of the codelab dir.
+// The returned func restores the original image URLs.
+func rebaseImages(steps []*types.Step) func() {
+	var imgs []*types.ImageNode
+	var src []string
+	for _, st := range steps {
+		for _, n := range imageNodes(st.Content.Nodes) {
+			if strings.HasPrefix(n.Src, imgDirname+string(filepath.Separator)) {
+				imgs = append(imgs, n)
+				src = append(src, n.Src)
+				n.Src = rebaseURL(filepath.ToSlash(n.Src))
+			}
+		}
+	}
+	return func() {
+		for i, n := range imgs {
+			n.Src = src[i]
+		}
+	}
+}
+
 func slurpImages(client *http.Client, src, dir string, steps []*types.Step) (map[string]string, error) {
 	// make sure img dir exists
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -223,6 +520,8 @@ func imageNodes(nodes []types.Node) []*types.ImageNode {
 			imgs = append(imgs, n)
 		case *types.ListNode:
 			imgs = append(imgs, imageNodes(n.Nodes)...)
+		case *types.ImportNode:
+			imgs = append(imgs, imageNodes(n.Content.Nodes)...)
 		case *types.ItemsListNode:
 			for _, i := range n.Items {
 				imgs = append(imgs, imageNodes(i.Nodes)...)
@@ -255,6 +554,12 @@ func importNodes(nodes []types.Node) []*types.ImportNode {
 			imps = append(imps, n)
 		case *types.ListNode:
 			imps = append(imps, importNodes(n.Nodes)...)
+		case *types.ItemsListNode:
+			for _, i := range n.Items {
+				imps = append(imps, importNodes(i.Nodes)...)
+			}
+		case *types.HeaderNode:
+			imps = append(imps, importNodes(n.Content.Nodes)...)
 		case *types.InfoboxNode:
 			imps = append(imps, importNodes(n.Content.Nodes)...)
 		case *types.GridNode:
@@ -268,21 +573,144 @@ func importNodes(nodes []types.Node) []*types.ImportNode {
 	return imps
 }
 
+// surveyNodes filters out everything except types.NodeSurvey nodes,
+// including those of imported fragments.
+func surveyNodes(nodes []types.Node) []*types.SurveyNode {
+	var surveys []*types.SurveyNode
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *types.SurveyNode:
+			surveys = append(surveys, n)
+		case *types.ListNode:
+			surveys = append(surveys, surveyNodes(n.Nodes)...)
+		case *types.ImportNode:
+			surveys = append(surveys, surveyNodes(n.Content.Nodes)...)
+		}
+	}
+	return surveys
+}
+
 // writeMeta writes codelab metadata to a local disk location
-// specified by path.
+// specified by path. The file is replaced atomically, see writeFileAtomic.
 func writeMeta(path string, cm *types.ContextMeta) error {
 	b, err := json.MarshalIndent(cm, "", "  ")
 	if err != nil {
 		return err
 	}
-	b = append(b, '\n')
-	return ioutil.WriteFile(path, b, 0644)
+	return writeFileAtomic(path, append(b, '\n'))
 }
 
 // codelabDir returns codelab root directory.
 // The base argument is codelab parent directory.
-func codelabDir(base string, m *types.Meta) string {
-	return filepath.Join(base, m.ID)
+//
+// It returns an error if m has no ID, or its ID is not normalized
+// with types.NormalizeID, so that the result is always a direct child of base.
+// This guards against IDs which did not come from a parser, such as
+// those stored in codelab metadata files.
+func codelabDir(base string, m *types.Meta) (string, error) {
+	if m.ID == "" {
+		return "", errors.New("missing codelab ID")
+	}
+	id, err := types.NormalizeID(m.ID)
+	if err != nil {
+		return "", err
+	}
+	if id != m.ID {
+		return "", fmt.Errorf("invalid codelab ID %q", m.ID)
+	}
+	return filepath.Join(base, id), nil
+}
+
+// variantDir returns the directory of codelab environment env,
+// named after layout, a slash-separated path relative to base where
+// "{id}" and "{env}" are replaced with the codelab ID and envSlug of env.
+// An empty layout results in codelabDir.
+//
+// It returns an error if the resulting path would not be a descendant
+// of base, e.g. because of a ".." element of layout.
+func variantDir(base string, m *types.Meta, env, layout string) (string, error) {
+	dir, err := codelabDir(base, m)
+	if err != nil || layout == "" {
+		return dir, err
+	}
+	slug := envSlug(env)
+	if slug == "" && strings.Contains(layout, "{env}") {
+		return "", fmt.Errorf("environment %q cannot be part of a dir name", env)
+	}
+	rel := strings.NewReplacer("{id}", m.ID, "{env}", slug).Replace(strings.Trim(layout, "/"))
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.Contains(elem, `\`) {
+			return "", fmt.Errorf("invalid environment dir %q", rel)
+		}
+	}
+	return filepath.Join(base, filepath.FromSlash(rel)), nil
+}
+
+// envSlugRegexp matches runs of characters envSlug replaces.
+var envSlugRegexp = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// envSlug returns env, usually an expression of environments such as
+// "web and not kiosk", in a form suitable for a dir name: lowercased,
+// with runs of characters other than letters, digits and '_' replaced
+// with a single '-', e.g. "web-and-not-kiosk".
+func envSlug(env string) string {
+	return strings.Trim(envSlugRegexp.ReplaceAllString(strings.ToLower(env), "-"), "-")
+}
+
+// variantBase is the reverse of variantDir: it returns base dir
+// of a codelab environment dir, exported with the specified layout.
+func variantBase(dir, layout string) string {
+	n := 1
+	if layout != "" {
+		n = strings.Count(strings.Trim(layout, "/"), "/") + 1
+	}
+	for i := 0; i < n; i++ {
+		dir = filepath.Join(dir, "..")
+	}
+	return dir
+}
+
+// duplicateDirs checks codelab dirs of sources, keyed by source,
+// for dirs shared by more than one source, which happens when sources
+// resolve to the same codelab ID. It returns an error for each such source,
+// keyed by source as well. None of them may be written: which one would win
+// would otherwise depend on the order they are written in.
+func duplicateDirs(srcDirs map[string][]string) map[string]error {
+	owners := make(map[string][]string) // absolute dir => sources
+	for src, dirs := range srcDirs {
+		for _, d := range dirs {
+			abs, err := filepath.Abs(d)
+			if err != nil {
+				abs = filepath.Clean(d)
+			}
+			owners[abs] = append(owners[abs], src)
+		}
+	}
+	shared := make([]string, 0, len(owners))
+	for dir, srcs := range owners {
+		if len(unique(srcs)) > 1 {
+			shared = append(shared, dir)
+		}
+	}
+	sort.Strings(shared)
+	errs := make(map[string]error)
+	for _, dir := range shared {
+		srcs := unique(owners[dir])
+		sort.Strings(srcs)
+		for _, src := range srcs {
+			if _, ok := errs[src]; ok {
+				continue
+			}
+			var others []string
+			for _, s := range srcs {
+				if s != src {
+					others = append(others, s)
+				}
+			}
+			errs[src] = fmt.Errorf("%s is also written from %s: the sources have the same codelab ID", dir, strings.Join(others, ", "))
+		}
+	}
+	return errs
 }
 
 // unique de-dupes a.
@@ -0,0 +1,330 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{"html", []string{"html"}},
+		{"html, md,,html", []string{"html", "md"}},
+		{"", nil},
+	}
+	for i, test := range tests {
+		out := parseList(test.in)
+		if len(out) == 0 && len(test.out) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("%d: parseList(%q) = %q; want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestWriteCodelabFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clab := &types.Codelab{Meta: types.Meta{ID: "lab", Title: "Lab"}}
+	st := clab.NewStep("Step")
+	img := types.NewImageNode(filepath.Join(imgDirname, "pic.png"))
+	st.Content.Append(img)
+	ctx := &types.Context{
+		Format:  "html",
+		Formats: []string{"html", "offline", "md", "json"},
+		Prefix:  "../../",
+	}
+	if err := writeCodelab(dir, clab, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"index.html", "index.md", "index.json", "offline/index.html"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "offline", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `src="../img/pic.png"`) {
+		t.Errorf("offline/index.html does not refer to ../img/pic.png:\n%s", b)
+	}
+	if img.Src != filepath.Join(imgDirname, "pic.png") {
+		t.Errorf("img.Src = %q; want it restored", img.Src)
+	}
+
+	meta, err := readMeta(filepath.Join(dir, metaFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(meta.Formats, ctx.Formats) {
+		t.Errorf("meta.Formats = %q; want %q", meta.Formats, ctx.Formats)
+	}
+}
+
+func TestVariantDir(t *testing.T) {
+	m := &types.Meta{ID: "lab"}
+	base := filepath.Join("out", "site")
+	tests := []struct {
+		env, layout, dir string
+	}{
+		{"web", "", filepath.Join(base, "lab")},
+		{"web", "{id}-{env}", filepath.Join(base, "lab-web")},
+		{"kiosk", "{env}/{id}", filepath.Join(base, "kiosk", "lab")},
+		{"Web and not (kiosk)", "{id}-{env}", filepath.Join(base, "lab-web-and-not-kiosk")},
+		{"../web", "{env}/{id}", filepath.Join(base, "web", "lab")},
+		{`a\b`, "{id}-{env}", filepath.Join(base, "lab-a-b")},
+	}
+	for i, test := range tests {
+		dir, err := variantDir(base, m, test.env, test.layout)
+		if err != nil {
+			t.Errorf("%d: variantDir(%q, %q): %v", i, test.env, test.layout, err)
+			continue
+		}
+		if dir != test.dir {
+			t.Errorf("%d: variantDir(%q, %q) = %q; want %q", i, test.env, test.layout, dir, test.dir)
+		}
+		if b := variantBase(dir, test.layout); b != base {
+			t.Errorf("%d: variantBase(%q, %q) = %q; want %q", i, dir, test.layout, b, base)
+		}
+	}
+}
+
+func TestVariantDirEscape(t *testing.T) {
+	tests := []struct {
+		id, env, layout string
+	}{
+		{"", "", ""},
+		{"../../etc", "", ""},
+		{"a/b", "", ""},
+		{"..", "web", "{id}-{env}"},
+		{" lab", "", ""},
+		{"lab", "../", "{env}/{id}"},
+		{"lab", "", "{id}-{env}"},
+		{"lab", "web", "../{id}"},
+		{"lab", "web", "{id}/./{env}"},
+		{"lab", "web", "{id}//{env}"},
+	}
+	for i, test := range tests {
+		m := &types.Meta{ID: test.id}
+		if dir, err := variantDir("out", m, test.env, test.layout); err == nil {
+			t.Errorf("%d: variantDir(%q, %q, %q) = %q; want error", i, test.id, test.env, test.layout, dir)
+		}
+	}
+}
+
+func TestDuplicateDirs(t *testing.T) {
+	srcDirs := map[string][]string{
+		"a.md": {filepath.Join("out", "lab-web"), filepath.Join("out", "lab-kiosk")},
+		"b.md": {filepath.Join("out", ".", "lab-kiosk")},
+		"c.md": {filepath.Join("out", "other")},
+	}
+	errs := duplicateDirs(srcDirs)
+	if len(errs) != 2 {
+		t.Errorf("duplicateDirs: %v; want errors of a.md and b.md", errs)
+	}
+	for src, other := range map[string]string{"a.md": "b.md", "b.md": "a.md"} {
+		if err := errs[src]; err == nil || !strings.Contains(err.Error(), "also written from "+other) {
+			t.Errorf("%s: %v; want the dir is also written from %s", src, err, other)
+		}
+	}
+}
+
+func TestExportEnvironments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "lab.md")
+	md := "id: lab\n\n# Lab\n\n## Step\n\n[[environment web]]\n\nWeb only.\n"
+	if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = filepath.Join(dir, "out")
+	*expenv = "web,kiosk"
+	*tmplout = "md"
+	if _, err := exportCodelab(src); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []string{"web", "kiosk"} {
+		d := filepath.Join(*output, "lab-"+env)
+		meta, err := readMeta(filepath.Join(d, metaFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Env != env || meta.EnvDir != defaultEnvDir || !reflect.DeepEqual(meta.Envs, []string{"web", "kiosk"}) {
+			t.Errorf("%s: meta.Context = %+v", env, meta.Context)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(d, "index.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if web := strings.Contains(string(b), "Web only."); web != (env == "web") {
+			t.Errorf("%s: index.md contains web content: %v", env, web)
+		}
+	}
+}
+
+func TestExportEnvironmentsAllOrNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "lab.md")
+	md := "id: lab\n\n# Lab\n\n## Step\n\nContent.\n"
+	if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a file in place of the second environment dir
+	out := filepath.Join(dir, "out")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(out, "lab-kiosk"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = out
+	*expenv = "web,kiosk"
+	*tmplout = "md"
+	if _, err := exportCodelab(src); err == nil {
+		t.Fatal("exportCodelab: no error")
+	}
+	if _, err := os.Stat(filepath.Join(out, "lab-web")); !os.IsNotExist(err) {
+		t.Errorf("lab-web has been written: %v", err)
+	}
+	files, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("output dir contains %d files; want only lab-kiosk", len(files))
+	}
+}
+
+func TestExportFragmentImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"lab.md":             "id: lab\n\n# Lab\n\n## Step\n\n[[import shared/setup.md]]\n",
+		"shared/setup.md":    "Setup\n\n![Console](console.png)\n",
+		"shared/console.png": "png",
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = filepath.Join(dir, "out")
+	*expenv = ""
+	*tmplout = "md"
+	if _, err := exportCodelab(filepath.Join(dir, "lab.md")); err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := ioutil.ReadDir(filepath.Join(*output, "lab", imgDirname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || filepath.Ext(imgs[0].Name()) != ".png" {
+		t.Fatalf("exported images = %v; want the fragment image", imgs)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(*output, "lab", "index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(imgDirname, imgs[0].Name()); !strings.Contains(string(b), want) {
+		t.Errorf("index.md does not refer to %s:\n%s", want, b)
+	}
+}
+
+func TestImportNodes(t *testing.T) {
+	a := types.NewImportNode("a.md")
+	b := types.NewImportNode("b.md")
+	c := types.NewImportNode("c.md")
+	items := types.NewItemsListNode("", 0)
+	items.NewItem(b)
+	nodes := []types.Node{
+		types.NewListNode(a),
+		items,
+		types.NewHeaderNode(3, c),
+	}
+	imps := importNodes(nodes)
+	if want := []*types.ImportNode{a, b, c}; !reflect.DeepEqual(imps, want) {
+		t.Errorf("importNodes() = %v; want %v", imps, want)
+	}
+}
+
+func TestExportDuplicateID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var srcs []string
+	for _, name := range []string{"a.md", "b.md"} {
+		src := filepath.Join(dir, name)
+		md := "id: lab\n\n# Lab " + name + "\n\n## Step\n\nContent.\n"
+		if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, src)
+	}
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = filepath.Join(dir, "out")
+	*expenv = ""
+	*tmplout = "md"
+	defer func(e int) { exit = e }(exit)
+	exit = 0
+
+	// neither source is written, whichever is fetched first
+	storeAll(srcs, exportDirs, storeCodelab)
+	if exit == 0 {
+		t.Errorf("exit = 0; want non-zero for a duplicate ID")
+	}
+	if _, err := os.Stat(filepath.Join(*output, "lab")); !os.IsNotExist(err) {
+		t.Errorf("lab dir: %v; want it not written", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// sitemapFilename and feedFilename are written next to the catalog
+	// by the index command.
+	sitemapFilename = "sitemap.xml"
+	feedFilename    = "atom.xml"
+
+	// feedTitle is the title and author name of the Atom feed.
+	feedTitle = "Codelabs"
+)
+
+// siteEntry is a published codelab of a catalog, with an absolute URL.
+type siteEntry struct {
+	*catalogEntry
+	URL string
+}
+
+// siteEntries returns published codelabs of c along with their absolute URLs,
+// resolved against base, or each codelab's export prefix if base is empty.
+// Codelabs without an absolute URL are returned as skipped.
+func siteEntries(c *catalog, base string) (entries []*siteEntry, skipped []*catalogEntry) {
+	for _, e := range c.Codelabs {
+		if !e.listed() {
+			continue
+		}
+		u, ok := absURL(base, e.Path)
+		if !ok {
+			u, ok = absURL(e.Prefix, e.Path)
+		}
+		if !ok {
+			skipped = append(skipped, e)
+			continue
+		}
+		entries = append(entries, &siteEntry{e, u})
+	}
+	return entries, skipped
+}
+
+// absURL resolves path p against base URL.
+// It returns false if base is not an absolute URL.
+func absURL(base, p string) (string, bool) {
+	u, err := url.Parse(base)
+	if err != nil || !u.IsAbs() {
+		return "", false
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	r, err := u.Parse(p)
+	if err != nil {
+		return "", false
+	}
+	return r.String(), true
+}
+
+// writeSite writes a sitemap and an Atom feed of published codelabs
+// of catalog c into dir. See siteEntries for how codelab URLs are resolved.
+// It returns codelabs which were skipped for the lack of an absolute URL.
+func writeSite(dir string, c *catalog, base string) ([]*catalogEntry, error) {
+	entries, skipped := siteEntries(c, base)
+	if len(entries) == 0 {
+		return skipped, nil
+	}
+	files := []struct {
+		name  string
+		write func(io.Writer, []*siteEntry) error
+	}{
+		{sitemapFilename, writeSitemap},
+		{feedFilename, func(w io.Writer, entries []*siteEntry) error {
+			u, _ := absURL(base, "")
+			return writeFeed(w, u, entries)
+		}},
+	}
+	for _, file := range files {
+		f, err := os.Create(filepath.Join(dir, file.name))
+		if err != nil {
+			return skipped, err
+		}
+		if err := file.write(f, entries); err != nil {
+			f.Close()
+			return skipped, err
+		}
+		if err := f.Close(); err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// sitemapURLSet is the root element of a sitemap.
+// See https://www.sitemaps.org/protocol.html.
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// writeSitemap writes a sitemap of entries to w,
+// with their update time as the last modification date.
+func writeSitemap(w io.Writer, entries []*siteEntry) error {
+	set := sitemapURLSet{}
+	for _, e := range entries {
+		u := sitemapURL{Loc: e.URL}
+		if e.Updated != nil {
+			u.LastMod = e.updated().UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	return writeXML(w, set)
+}
+
+// atomFeed is the root element of an Atom feed.
+// See https://tools.ietf.org/html/rfc4287.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    *atomLink   `xml:"link,omitempty"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title    string         `xml:"title"`
+	ID       string         `xml:"id"`
+	Link     atomLink       `xml:"link"`
+	Updated  string         `xml:"updated"`
+	Summary  string         `xml:"summary,omitempty"`
+	Author   *atomPerson    `xml:"author,omitempty"`
+	Category []atomCategory `xml:"category"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// writeFeed writes an Atom feed of entries to w.
+// The home argument is the absolute URL of the site, if known.
+//
+// Entries are expected to be sorted by their update time, most recent first.
+// The feed update time is that of the first entry, or current time
+// if none of the entries have one. The same fallback is used for entries
+// without an update time, since Atom requires one.
+func writeFeed(w io.Writer, home string, entries []*siteEntry) error {
+	updated := time.Now()
+	if len(entries) > 0 && entries[0].Updated != nil {
+		updated = entries[0].updated()
+	}
+	feed := atomFeed{
+		Title:   feedTitle,
+		ID:      home,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: feedTitle},
+	}
+	if home != "" {
+		feed.Link = &atomLink{Href: home}
+	} else if len(entries) > 0 {
+		feed.ID = entries[0].URL
+	}
+	for _, e := range entries {
+		t := updated
+		if e.Updated != nil {
+			t = e.updated()
+		}
+		ae := atomEntry{
+			Title:   e.Title,
+			ID:      e.URL,
+			Link:    atomLink{Href: e.URL},
+			Updated: t.UTC().Format(time.RFC3339),
+			Summary: e.Summary,
+		}
+		if e.Author != "" {
+			ae.Author = &atomPerson{Name: e.Author}
+		}
+		for _, c := range e.Categories {
+			ae.Category = append(ae.Category, atomCategory{Term: c})
+		}
+		feed.Entries = append(feed.Entries, ae)
+	}
+	return writeXML(w, feed)
+}
+
+// writeXML writes v to w as an indented XML document.
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
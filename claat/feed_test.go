@@ -0,0 +1,91 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+func TestAbsURL(t *testing.T) {
+	tests := []struct {
+		base, path, out string
+		ok              bool
+	}{
+		{"https://example.com", "lab/", "https://example.com/lab/", true},
+		{"https://example.com/codelabs/", "lab/", "https://example.com/codelabs/lab/", true},
+		{"https://example.com/codelabs", "lab/", "https://example.com/codelabs/lab/", true},
+		{"../../", "lab/", "", false},
+		{"", "lab/", "", false},
+	}
+	for i, test := range tests {
+		out, ok := absURL(test.base, test.path)
+		if out != test.out || ok != test.ok {
+			t.Errorf("%d: absURL(%q, %q) = %q, %v; want %q, %v", i, test.base, test.path, out, ok, test.out, test.ok)
+		}
+	}
+}
+
+func TestWriteSite(t *testing.T) {
+	ct := types.ContextTime(time.Date(2016, 3, 4, 5, 6, 7, 0, time.UTC))
+	draft := types.LegacyStatus{"draft"}
+	cat := newCatalog([]*catalogEntry{
+		{Meta: types.Meta{ID: "lab", Title: "Lab", Summary: "A <lab>."}, Updated: &ct, Path: "lab/"},
+		{Meta: types.Meta{ID: "prefixed", Title: "Prefixed"}, Path: "prefixed/", Prefix: "https://cdn.example.com/"},
+		{Meta: types.Meta{ID: "relative"}, Path: "relative/", Prefix: "../../"},
+		{Meta: types.Meta{ID: "draft", Status: &draft}, Path: "draft/"},
+	}, true)
+
+	entries, skipped := siteEntries(cat, "")
+	if len(skipped) != 2 || len(entries) != 1 || entries[0].URL != "https://cdn.example.com/prefixed/" {
+		t.Errorf("siteEntries without base: %d entries, %d skipped", len(entries), len(skipped))
+	}
+	entries, skipped = siteEntries(cat, "https://example.com/")
+	if len(skipped) != 0 || len(entries) != 3 {
+		t.Fatalf("siteEntries: %d entries, %d skipped; want 3, 0", len(entries), len(skipped))
+	}
+
+	var buf bytes.Buffer
+	if err := writeSitemap(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<url>\n    <loc>https://example.com/lab/</loc>\n    <lastmod>2016-03-04T05:06:07Z</lastmod>\n  </url>",
+		"<url>\n    <loc>https://example.com/prefixed/</loc>\n  </url>",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("sitemap does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := writeFeed(&buf, "https://example.com/", entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<id>https://example.com/</id>",
+		"<updated>2016-03-04T05:06:07Z</updated>",
+		"<id>https://example.com/lab/</id>",
+		"<summary>A &lt;lab&gt;.</summary>",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("feed does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -39,35 +40,51 @@ const (
 	srcInvalid   srcType = ""
 	srcGoogleDoc srcType = "gdoc" // Google Docs doc
 	srcMarkdown  srcType = "md"   // Markdown text
+	srcJSON      srcType = "json" // JSON encoded codelab, see types.JSONVersion
 
 	// driveAPI is a base URL for Drive API
 	driveAPI = "https://www.googleapis.com/drive/v3"
+
+	// maxImportDepth is how deep imported fragments can import other fragments.
+	maxImportDepth = 5
+
+	// fetchTimeout limits each HTTP request made to fetch codelab sources
+	// and their assets, including reading of the response body.
+	fetchTimeout = 5 * time.Minute
 )
 
+// defaultClient is used to fetch remote resources which are not Google Docs.
+var defaultClient = &http.Client{Timeout: fetchTimeout}
+
 // srcType is codelab source type
 type srcType string
 
 // resource is a codelab resource, loaded from local file
 // or fetched from remote location.
 type resource struct {
-	typ  srcType       // source type
-	body io.ReadCloser // resource body
-	mod  time.Time     // last update of content
+	typ   srcType       // source type
+	body  io.ReadCloser // resource body
+	mod   time.Time     // last update of content
+	local bool          // resource is a local file
 }
 
 // codelab wraps types.Codelab, while adding source type
 // and modified timestamp fields.
 type codelab struct {
 	*types.Codelab
-	typ srcType   //  source type
-	mod time.Time // last modified timestamp
+	typ   srcType   //  source type
+	mod   time.Time // last modified timestamp
+	files []string  // local files the codelab was read from, see slurpCodelab
 }
 
 // slurpCodelab retrieves and parses codelab source.
 // It returns parsed codelab and its source type.
 //
 // The function will also fetch and parse fragments included
-// with types.ImportNode.
+// with types.ImportNode, recursively.
+//
+// Local files the codelab is made of are recorded in files of the result:
+// src itself, imported fragments and images, if src is a local file.
 func slurpCodelab(src string) (*codelab, error) {
 	res, err := fetch(src)
 	if err != nil {
@@ -80,62 +97,245 @@ func slurpCodelab(src string) (*codelab, error) {
 	}
 
 	// fetch imports and parse them as fragments
-	var imports []*types.ImportNode
+	var nodes []types.Node
 	for _, st := range clab.Steps {
-		imports = append(imports, importNodes(st.Content.Nodes)...)
+		nodes = append(nodes, st.Content.Nodes...)
+	}
+	imp := &importer{name: src, local: res.local}
+	key, err := imp.key()
+	if err != nil {
+		return nil, err
+	}
+	files, err := slurpImports(imp, nodes, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	assignSurveyIDs(clab.ID, nodes)
+	if res.local {
+		files = append(files, src)
+		files = append(files, localImages(src, nodes)...)
+	}
+
+	v := &codelab{
+		Codelab: clab,
+		typ:     res.typ,
+		mod:     res.mod,
+		files:   unique(files),
 	}
-	ch := make(chan error, len(imports))
+	return v, nil
+}
+
+// assignSurveyIDs gives surveys of nodes which have no ID, such as those
+// of imported fragments, a unique one made of the codelab id and a number.
+func assignSurveyIDs(id string, nodes []types.Node) {
+	surveys := surveyNodes(nodes)
+	used := make(map[string]bool, len(surveys))
+	for _, n := range surveys {
+		used[n.ID] = true
+	}
+	var i int
+	for _, n := range surveys {
+		if n.ID != "" {
+			continue
+		}
+		for used[n.ID] {
+			i++
+			n.ID = fmt.Sprintf("%s-%d", id, i)
+		}
+		used[n.ID] = true
+	}
+}
+
+// localImages returns paths of local image files referenced in nodes
+// of codelab src, resolved the same way slurpBytes does.
+// Images which slurpBytes would reject are skipped.
+func localImages(src string, nodes []types.Node) []string {
+	var files []string
+	for _, n := range imageNodes(nodes) {
+		u, err := url.Parse(n.Src)
+		if err != nil || u.Host != "" {
+			continue
+		}
+		if p, err := restrictPathToParent(n.Src, filepath.Dir(src)); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// localizeImages makes local images of nodes, a fragment in directory dir,
+// relative to dir rather than the importing codelab, by rewriting their
+// source to an absolute path.
+func localizeImages(dir string, nodes []types.Node) {
+	for _, n := range imageNodes(nodes) {
+		u, err := url.Parse(n.Src)
+		if err != nil || u.Host != "" || filepath.IsAbs(n.Src) {
+			continue
+		}
+		n.Src = filepath.Join(dir, n.Src)
+	}
+}
+
+// importer is a resource which may contain types.ImportNode.
+type importer struct {
+	name  string // local file path, URL or a Google Doc ID
+	local bool   // name is a local file path
+}
+
+// key returns a string uniquely identifying imp,
+// used to detect import cycles.
+func (imp *importer) key() (string, error) {
+	if imp.local {
+		return filepath.Abs(imp.name)
+	}
+	u, err := url.Parse(imp.name)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" || u.Host == "docs.google.com" {
+		return gdocID(imp.name), nil
+	}
+	return imp.name, nil
+}
+
+// slurpImports fetches and parses fragments referenced by import nodes
+// found in nodes, setting their content to the result.
+// Imported fragments are resolved the same way, recursively,
+// up to maxImportDepth levels deep.
+//
+// The chain argument contains keys of all importers leading to imp,
+// including imp itself, and is used to detect import cycles.
+//
+// It returns paths of local fragments, including those imported
+// by other fragments.
+func slurpImports(imp *importer, nodes []types.Node, chain []string) ([]string, error) {
+	type result struct {
+		files []string
+		err   error
+	}
+	imports := importNodes(nodes)
+	ch := make(chan *result, len(imports))
 	defer close(ch)
-	for _, imp := range imports {
+	for _, n := range imports {
 		go func(n *types.ImportNode) {
-			frag, err := slurpFragment(n.URL)
+			frag, files, err := slurpFragment(imp, n.URL, chain)
 			if err != nil {
-				ch <- fmt.Errorf("%s: %v", n.URL, err)
+				ch <- &result{err: fmt.Errorf("%s: %v", n.URL, err)}
 				return
 			}
 			n.Content.Nodes = frag
-			ch <- nil
-		}(imp)
+			ch <- &result{files: files}
+		}(n)
 	}
+	var files []string
+	var err error
 	for _ = range imports {
-		if err := <-ch; err != nil {
-			return nil, err
+		// wait for all goroutines to finish and record the first error
+		r := <-ch
+		if r.err != nil && err == nil {
+			err = r.err
 		}
+		files = append(files, r.files...)
 	}
+	return files, err
+}
 
-	v := &codelab{
-		Codelab: clab,
-		typ:     res.typ,
-		mod:     res.mod,
+// slurpFragment retrieves and parses fragment name, imported by imp.
+// Along with the fragment nodes, it returns paths of local fragments
+// it is made of. See slurpImports for details.
+func slurpFragment(imp *importer, name string, chain []string) ([]types.Node, []string, error) {
+	if len(chain) > maxImportDepth {
+		return nil, nil, fmt.Errorf("import depth limit of %d exceeded: %s", maxImportDepth, strings.Join(chain, " -> "))
 	}
-	return v, nil
+	frag, err := resolveImport(imp, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := frag.key()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, k := range chain {
+		if k == key {
+			return nil, nil, fmt.Errorf("import cycle: %s -> %s", strings.Join(chain, " -> "), key)
+		}
+	}
+
+	var res *resource
+	if frag.local {
+		res, err = fetchLocal(frag.name)
+	} else {
+		res, err = fetchRemote(frag.name, true)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.body.Close()
+	nodes, err := parser.ParseFragment(string(res.typ), res.body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if frag.local {
+		localizeImages(filepath.Dir(frag.name), nodes)
+	}
+	// copy chain: goroutines of the same level share its backing array
+	next := append(chain[:len(chain):len(chain)], key)
+	files, err := slurpImports(frag, nodes, next)
+	if frag.local {
+		files = append(files, frag.name)
+	}
+	return nodes, files, err
 }
 
-func slurpFragment(url string) ([]types.Node, error) {
-	res, err := fetchRemote(url, true)
+// resolveImport resolves name imported by imp.
+//
+// Imports of a local importer without the host part are resolved relative
+// to the importer's directory, the same way local images are,
+// and the resulting file must exist.
+func resolveImport(imp *importer, name string) (*importer, error) {
+	u, err := url.Parse(name)
 	if err != nil {
 		return nil, err
 	}
-	defer res.body.Close()
-	return parser.ParseFragment(string(res.typ), res.body)
+	if !imp.local || u.Host != "" {
+		return &importer{name: name}, nil
+	}
+	p, err := restrictPathToParent(name, filepath.Dir(imp.name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return nil, err
+	}
+	return &importer{name: p, local: true}, nil
 }
 
 // fetch retrieves codelab doc either from local disk
 // or a remote location.
 // The caller is responsible for closing returned stream.
 func fetch(name string) (*resource, error) {
-	fi, err := os.Stat(name)
-	if os.IsNotExist(err) {
+	if _, err := os.Stat(name); os.IsNotExist(err) {
 		return fetchRemote(name, false)
 	}
+	return fetchLocal(name)
+}
+
+// fetchLocal retrieves resource from a local file.
+// It is a special case of fetch function.
+func fetchLocal(name string) (*resource, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
 	r, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
 	return &resource{
-		body: r,
-		typ:  srcMarkdown,
-		mod:  fi.ModTime(),
+		body:  r,
+		typ:   fileSrcType(name),
+		mod:   fi.ModTime(),
+		local: true,
 	}, nil
 }
 
@@ -172,10 +372,20 @@ func fetchRemoteFile(url string) (*resource, error) {
 	return &resource{
 		body: res.Body,
 		mod:  t,
-		typ:  srcMarkdown,
+		typ:  fileSrcType(res.Request.URL.Path),
 	}, nil
 }
 
+// fileSrcType returns source type of a file, be it local or remote,
+// based on its name extension.
+// Files without a known extension are assumed to be Markdown.
+func fileSrcType(name string) srcType {
+	if strings.ToLower(path.Ext(name)) == ".json" {
+		return srcJSON
+	}
+	return srcMarkdown
+}
+
 // fetchDriveFile uses Drive API to retrieve HTML representation of a Google Doc.
 // See https://developers.google.com/drive/web/manage-downloads#downloading_google_documents
 // for more details.
@@ -197,6 +407,31 @@ func fetchDriveFile(id string, nometa bool) (*resource, error) {
 		return &resource{body: res.Body, typ: srcGoogleDoc}, nil
 	}
 
+	meta, err := driveFileMeta(client, id)
+	if err != nil {
+		return nil, err
+	}
+	res, err := retryGet(client, exportURL, 7)
+	if err != nil {
+		return nil, err
+	}
+	return &resource{
+		body: res.Body,
+		mod:  meta.Modified,
+		typ:  srcGoogleDoc,
+	}, nil
+}
+
+// driveMeta is Google Doc metadata, as returned by Drive API.
+type driveMeta struct {
+	ID       string    `json:"id"`
+	MimeType string    `json:"mimeType"`
+	Modified time.Time `json:"modifiedTime"`
+}
+
+// driveFileMeta retrieves metadata of Google Doc id using Drive API.
+// It fails if id is not a Google Doc.
+func driveFileMeta(client *http.Client, id string) (*driveMeta, error) {
 	q := url.Values{
 		"fields":             {"id,mimeType,modifiedTime"},
 		"supportsTeamDrives": {"true"},
@@ -207,26 +442,52 @@ func fetchDriveFile(id string, nometa bool) (*resource, error) {
 		return nil, err
 	}
 	defer res.Body.Close()
-	meta := &struct {
-		ID       string    `json:"id"`
-		MimeType string    `json:"mimeType"`
-		Modified time.Time `json:"modifiedTime"`
-	}{}
+	meta := &driveMeta{}
 	if err := json.NewDecoder(res.Body).Decode(meta); err != nil {
 		return nil, err
 	}
 	if meta.MimeType != "application/vnd.google-apps.document" {
 		return nil, fmt.Errorf("%s: invalid mime type: %s", id, meta.MimeType)
 	}
+	return meta, nil
+}
 
-	if res, err = retryGet(client, exportURL, 7); err != nil {
-		return nil, err
+// fetchModTime retrieves last modification time of codelab doc name,
+// which can be a local file, a URL or a Google Doc ID, without fetching
+// its contents, using the same clients as fetch.
+//
+// It returns zero time if the modification time is unknown or cannot be
+// retrieved, be it a Drive API or a HEAD request failure. The doc is then
+// considered changed and fetched as usual, which reports any errors.
+func fetchModTime(name string) time.Time {
+	if fi, err := os.Stat(name); err == nil {
+		return fi.ModTime()
 	}
-	return &resource{
-		body: res.Body,
-		mod:  meta.Modified,
-		typ:  srcGoogleDoc,
-	}, nil
+	u, err := url.Parse(name)
+	if err != nil {
+		return time.Time{}
+	}
+	if u.Host == "" || u.Host == "docs.google.com" {
+		client, err := driveClient()
+		if err != nil {
+			return time.Time{}
+		}
+		meta, err := driveFileMeta(client, gdocID(name))
+		if err != nil {
+			return time.Time{}
+		}
+		return meta.Modified
+	}
+	res, err := retryRequest(nil, "HEAD", name, 1)
+	if err != nil {
+		return time.Time{}
+	}
+	res.Body.Close()
+	t, err := http.ParseTime(res.Header.Get("last-modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
 }
 
 var crcTable = crc64.MakeTable(crc64.ECMA)
@@ -272,15 +533,24 @@ func slurpRemoteBytes(client *http.Client, dir, url string, n int) ([]byte, erro
 // retryGet tries to GET specified url up to n times.
 // Default client will be used if not provided.
 func retryGet(client *http.Client, url string, n int) (*http.Response, error) {
+	return retryRequest(client, "GET", url, n)
+}
+
+// retryRequest is like retryGet, with the specified request method.
+func retryRequest(client *http.Client, method, url string, n int) (*http.Response, error) {
 	if client == nil {
-		client = http.DefaultClient
+		client = defaultClient
 	}
 	for i := 0; i <= n; i++ {
 		if i > 0 {
 			t := time.Duration((math.Pow(2, float64(i)) + rand.Float64()) * float64(time.Second))
 			time.Sleep(t)
 		}
-		res, err := client.Get(url)
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req)
 		// return early with a good response
 		// the rest is error handling
 		if err == nil && res.StatusCode == http.StatusOK {
@@ -20,10 +20,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/googlecodelabs/tools/claat/render"
 	"github.com/googlecodelabs/tools/claat/types"
@@ -103,6 +107,43 @@ func TestFetchRemoteDrive(t *testing.T) {
 	}
 }
 
+func TestFetchModTime(t *testing.T) {
+	mod := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("r.Method = %q; want HEAD", r.Method)
+		}
+		if r.URL.Path == "/missing.md" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Last-Modified", mod.Format(http.TimeFormat))
+	}))
+	defer ts.Close()
+
+	if v := fetchModTime(ts.URL + "/lab.md"); !v.Equal(mod) {
+		t.Errorf("fetchModTime(lab.md) = %v; want %v", v, mod)
+	}
+	// a failed HEAD request means the doc is considered changed
+	if v := fetchModTime(ts.URL + "/missing.md"); !v.IsZero() {
+		t.Errorf("fetchModTime(missing.md) = %v; want zero time", v)
+	}
+}
+
+func TestFetchModTimeDrive(t *testing.T) {
+	rt := &testTransport{func(r *http.Request) (*http.Response, error) {
+		b := ioutil.NopCloser(strings.NewReader(`{"error": {"errors": [{"reason": "notFound"}]}}`))
+		return &http.Response{Body: b, StatusCode: http.StatusNotFound}, nil
+	}}
+	clients[providerGoogle] = &http.Client{Transport: rt}
+	defer delete(clients, providerGoogle)
+
+	// same as a failed HEAD request
+	if v := fetchModTime("doc-123"); !v.IsZero() {
+		t.Errorf("fetchModTime(doc-123) = %v; want zero time", v)
+	}
+}
+
 func TestSlurpWithFragment(t *testing.T) {
 	dochtml, err := ioutil.ReadFile("testdata/gdoc.html")
 	if err != nil {
@@ -161,6 +202,98 @@ func TestSlurpWithFragment(t *testing.T) {
 	}
 }
 
+func TestSlurpLocalImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"codelab.md":        "id: test\n\n# Title\n\n## Step\n\n[[import shared/setup.md]]\n",
+		"shared/setup.md":   "Setup\n\n[[import billing.md]]\n",
+		"shared/billing.md": "Billing warning\n",
+		"cycle.md":          "id: cycle\n\n# Title\n\n## Step\n\n[[import shared/a.md]]\n",
+		"shared/a.md":       "A\n\n[[import b.md]]\n",
+		"shared/b.md":       "B\n\n[[import a.md]]\n",
+		"escape.md":         "id: escape\n\n# Title\n\n## Step\n\n[[import ../outside.md]]\n",
+		"missing.md":        "id: missing\n\n# Title\n\n## Step\n\n[[import shared/missing.md]]\n",
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clab, err := slurpCodelab(filepath.Join(dir, "codelab.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err := render.HTML("", clab.Steps[0].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Setup", "Billing warning"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("%s does not contain %q", html, want)
+		}
+	}
+	sort.Strings(clab.files)
+	wantFiles := []string{
+		filepath.Join(dir, "codelab.md"),
+		filepath.Join(dir, "shared/billing.md"),
+		filepath.Join(dir, "shared/setup.md"),
+	}
+	if !reflect.DeepEqual(clab.files, wantFiles) {
+		t.Errorf("clab.files = %v; want %v", clab.files, wantFiles)
+	}
+
+	tests := []struct{ src, err string }{
+		{"cycle.md", "import cycle"},
+		{"escape.md", "isn't a subdirectory"},
+		{"missing.md", "no such file"},
+	}
+	for _, test := range tests {
+		_, err := slurpCodelab(filepath.Join(dir, test.src))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: err = %v; want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestSlurpFragmentSurveys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	survey := "[[survey]]\n\n#### Question?\n\n* Yes\n* No\n"
+	files := map[string]string{
+		"codelab.md": "id: lab\n\n# Title\n\n## Step\n\n" + survey + "\n## Next\n\n[[import poll.md]]\n",
+		"poll.md":    survey,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clab, err := slurpCodelab(filepath.Join(dir, "codelab.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, st := range clab.Steps {
+		for _, n := range surveyNodes(st.Content.Nodes) {
+			ids = append(ids, n.ID)
+		}
+	}
+	if want := []string{"lab-1", "lab-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("survey IDs = %v; want %v", ids, want)
+	}
+}
+
 func TestGdocID(t *testing.T) {
 	tests := []struct{ in, out string }{
 		{"https://docs.google.com/document/d/foo", "foo"},
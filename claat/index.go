@@ -0,0 +1,376 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+const (
+	// catalogFilename is the site catalog written by the index command,
+	// relative to the output dir.
+	catalogFilename = "codelabs.json"
+	// indexFilename is the landing page written next to the catalog.
+	indexFilename = "index.html"
+	// uncategorized is the group name of codelabs without categories.
+	uncategorized = "Other"
+)
+
+// unlistedStatus contains codelab statuses excluded from the index
+// unless -all is specified. Keys are lower case.
+var unlistedStatus = map[string]bool{
+	"draft":  true,
+	"hidden": true,
+}
+
+// cmdIndex is the "claat index ..." subcommand.
+func cmdIndex() {
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	dirs, err := scanPaths(roots)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(dirs) == 0 {
+		fatalf("no codelabs found in %s", strings.Join(roots, ", "))
+	}
+
+	base := *output
+	if isStdout(base) {
+		base = "."
+	}
+	var entries []*catalogEntry
+	for _, d := range dirs {
+		meta, err := readMeta(filepath.Join(d, metaFilename))
+		if err != nil {
+			errorf(reportErr, d, err)
+			continue
+		}
+		e, err := newCatalogEntry(base, d, meta)
+		if err != nil {
+			errorf(reportErr, d, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	cat := newCatalog(entries, *indexAll)
+
+	if isStdout(*output) {
+		b, err := json.MarshalIndent(cat, "", "  ")
+		if err != nil {
+			fatalf("%v", err)
+		}
+		os.Stdout.Write(append(b, '\n'))
+		return
+	}
+	if err := writeIndex(*output, cat); err != nil {
+		fatalf("%v", err)
+	}
+	skipped, err := writeSite(*output, cat, *baseURL)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	for _, e := range skipped {
+		printf("skip\t%s no absolute URL for %s and %s; use -baseurl", e.ID, sitemapFilename, feedFilename)
+	}
+	printf(reportOk, filepath.Join(*output, catalogFilename))
+}
+
+// catalog is the site index of exported codelabs,
+// stored in the catalogFilename file.
+type catalog struct {
+	Codelabs   []*catalogEntry `json:"codelabs"`   // Sorted by update time, most recent first
+	Categories []string        `json:"categories"` // All categories of Codelabs, sorted
+	Themes     []string        `json:"themes"`     // All themes of Codelabs, sorted
+	Tags       []string        `json:"tags"`       // All environments of Codelabs, sorted
+	Statuses   []string        `json:"statuses"`   // All statuses of Codelabs, sorted
+}
+
+// catalogEntry is a single codelab of a catalog.
+type catalogEntry struct {
+	types.Meta
+	Format  string             `json:"format"`            // Export format, e.g. "html"
+	Updated *types.ContextTime `json:"updated,omitempty"` // Last export timestamp
+	Path    string             `json:"path"`              // Codelab dir, relative to the catalog
+	Prefix  string             `json:"-"`                 // Export URL prefix, see siteEntries
+}
+
+// newCatalogEntry creates a catalog entry of the codelab exported to dir,
+// with its path relative to the base dir of the catalog.
+func newCatalogEntry(base, dir string, meta *types.ContextMeta) (*catalogEntry, error) {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(absBase, absDir)
+	if err != nil {
+		return nil, err
+	}
+	return &catalogEntry{
+		Meta:    meta.Meta,
+		Format:  meta.Format,
+		Updated: meta.Updated,
+		Path:    filepath.ToSlash(rel) + "/",
+		Prefix:  meta.Prefix,
+	}, nil
+}
+
+// updated returns e.Updated as time.Time, or zero time if it is not set.
+func (e *catalogEntry) updated() time.Time {
+	if e.Updated == nil {
+		return time.Time{}
+	}
+	return time.Time(*e.Updated)
+}
+
+// statuses returns e's statuses normalized to lower case.
+func (e *catalogEntry) statuses() []string {
+	if e.Status == nil {
+		return nil
+	}
+	var s []string
+	for _, v := range *e.Status {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+// listed reports whether e is included in the index by default.
+func (e *catalogEntry) listed() bool {
+	for _, s := range e.statuses() {
+		if unlistedStatus[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// newCatalog creates a catalog of entries, sorted by their update time.
+// Unless all is true, entries with a draft or hidden status are excluded.
+func newCatalog(entries []*catalogEntry, all bool) *catalog {
+	cat := &catalog{Codelabs: []*catalogEntry{}}
+	var cats, themes, tags, statuses []string
+	for _, e := range entries {
+		if !all && !e.listed() {
+			continue
+		}
+		cat.Codelabs = append(cat.Codelabs, e)
+		cats = append(cats, e.Categories...)
+		if e.Theme != "" {
+			themes = append(themes, e.Theme)
+		}
+		tags = append(tags, e.Tags...)
+		statuses = append(statuses, e.statuses()...)
+	}
+	sort.Sort(byUpdated(cat.Codelabs))
+	cat.Categories = sortedUnique(cats)
+	cat.Themes = sortedUnique(themes)
+	cat.Tags = sortedUnique(tags)
+	cat.Statuses = sortedUnique(statuses)
+	return cat
+}
+
+// byUpdated sorts catalog entries by update time, most recent first,
+// and then by ID.
+type byUpdated []*catalogEntry
+
+func (a byUpdated) Len() int      { return len(a) }
+func (a byUpdated) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUpdated) Less(i, j int) bool {
+	if ti, tj := a[i].updated(), a[j].updated(); !ti.Equal(tj) {
+		return ti.After(tj)
+	}
+	return a[i].ID < a[j].ID
+}
+
+// catalogGroup is a category section of the index page.
+type catalogGroup struct {
+	Name     string
+	Codelabs []*catalogEntry
+}
+
+// groups returns codelabs of c grouped by category, in the order of c.Categories.
+// A codelab with multiple categories is included in each of their groups.
+// Codelabs without a category are grouped last, under uncategorized name.
+func (c *catalog) groups() []*catalogGroup {
+	index := make(map[string]*catalogGroup)
+	var groups []*catalogGroup
+	for _, name := range c.Categories {
+		g := &catalogGroup{Name: name}
+		index[name] = g
+		groups = append(groups, g)
+	}
+	var other []*catalogEntry
+	for _, e := range c.Codelabs {
+		if len(e.Categories) == 0 {
+			other = append(other, e)
+			continue
+		}
+		for _, name := range sortedUnique(e.Categories) {
+			index[name].Codelabs = append(index[name].Codelabs, e)
+		}
+	}
+	if len(other) > 0 {
+		groups = append(groups, &catalogGroup{Name: uncategorized, Codelabs: other})
+	}
+	return groups
+}
+
+// writeIndex writes catalog c and its landing page into dir.
+func writeIndex(dir string, c *catalog) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, catalogFilename), b, 0644); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, indexFilename))
+	if err != nil {
+		return err
+	}
+	data := struct {
+		*catalog
+		Groups []*catalogGroup
+	}{c, c.groups()}
+	if err := indexTemplate.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sortedUnique returns a sorted copy of a, with duplicates removed.
+func sortedUnique(a []string) []string {
+	res := unique(a)
+	sort.Strings(res)
+	return res
+}
+
+// indexTemplate is the landing page of the index command.
+// Filtering is done client-side, using data attributes of each codelab card.
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"statuses": func(e *catalogEntry) []string {
+		return e.statuses()
+	},
+	"filter": func(name, label string, values []string) interface{} {
+		return struct {
+			Name, Label string
+			Values      []string
+		}{name, label, values}
+	},
+	"date": func(t *types.ContextTime) string {
+		if t == nil {
+			return ""
+		}
+		return time.Time(*t).Format("2006-01-02")
+	},
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Codelabs</title>
+<style>
+body { font-family: Roboto, Arial, sans-serif; margin: 0 auto; max-width: 960px; padding: 0 16px; }
+.filters select { margin-right: 8px; }
+.codelab { border: 1px solid #ddd; border-radius: 4px; display: inline-block; margin: 8px; padding: 12px; vertical-align: top; width: 260px; }
+.codelab p { color: #555; }
+.codelab .updated { color: #999; font-size: 12px; }
+[hidden] { display: none !important; }
+</style>
+</head>
+<body>
+<h1>Codelabs</h1>
+<div class="filters">
+{{template "filter" (filter "category" "All categories" .Categories)}}
+{{template "filter" (filter "theme" "All themes" .Themes)}}
+{{template "filter" (filter "tags" "All environments" .Tags)}}
+{{template "filter" (filter "status" "All statuses" .Statuses)}}
+</div>
+{{range .Groups}}
+<section class="group" data-category="{{.Name}}">
+<h2>{{.Name}}</h2>
+{{range .Codelabs}}
+<div class="codelab" data-category="{{join .Categories ","}}" data-theme="{{.Theme}}" data-tags="{{join .Tags ","}}" data-status="{{join (statuses .) ","}}">
+<h3><a href="{{.Path}}">{{.Title}}</a></h3>
+<p>{{.Summary}}</p>
+{{if .Duration}}<div class="duration">{{.Duration}} min</div>{{end}}
+{{with date .Updated}}<div class="updated">Updated {{.}}</div>{{end}}
+</div>
+{{end}}
+</section>
+{{end}}
+<script>
+(function() {
+  var filters = document.querySelectorAll('.filters select');
+  function has(list, v) {
+    return list.toLowerCase().split(',').indexOf(v.toLowerCase()) >= 0;
+  }
+  function apply() {
+    var groups = document.querySelectorAll('.group');
+    for (var i = 0; i < groups.length; i++) {
+      var cards = groups[i].querySelectorAll('.codelab');
+      var visible = 0;
+      for (var j = 0; j < cards.length; j++) {
+        var show = true;
+        for (var k = 0; k < filters.length; k++) {
+          var v = filters[k].value;
+          if (v && !has(cards[j].dataset[filters[k].name], v)) {
+            show = false;
+          }
+        }
+        cards[j].hidden = !show;
+        if (show) {
+          visible++;
+        }
+      }
+      groups[i].hidden = visible == 0;
+    }
+  }
+  for (var i = 0; i < filters.length; i++) {
+    filters[i].addEventListener('change', apply);
+  }
+})();
+</script>
+</body>
+</html>
+{{define "filter"}}{{if .Values}}<select name="{{.Name}}">
+<option value="">{{.Label}}</option>
+{{range .Values}}<option>{{.}}</option>
+{{end}}</select>{{end}}{{end}}
+`))
@@ -0,0 +1,130 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+func TestNewCatalog(t *testing.T) {
+	day := func(d int) *types.ContextTime {
+		ct := types.ContextTime(time.Date(2016, 1, d, 0, 0, 0, 0, time.UTC))
+		return &ct
+	}
+	status := func(s ...string) *types.LegacyStatus {
+		ls := types.LegacyStatus(s)
+		return &ls
+	}
+	entries := []*catalogEntry{
+		{Meta: types.Meta{ID: "old", Categories: []string{"Web"}, Theme: "web"}, Updated: day(1)},
+		{Meta: types.Meta{ID: "new", Categories: []string{"Web", "Android"}, Tags: []string{"web"}}, Updated: day(3)},
+		{Meta: types.Meta{ID: "draft", Categories: []string{"Cloud"}, Status: status("Draft")}, Updated: day(4)},
+		{Meta: types.Meta{ID: "hidden", Status: status("published", "hidden")}, Updated: day(5)},
+		{Meta: types.Meta{ID: "plain", Status: status("Published")}},
+		{Meta: types.Meta{ID: "also-new"}, Updated: day(3)},
+	}
+	tests := []struct {
+		all        bool
+		ids        []string
+		categories []string
+		groups     []string // "name:id,id"
+	}{
+		{
+			ids:        []string{"also-new", "new", "old", "plain"},
+			categories: []string{"Android", "Web"},
+			groups:     []string{"Android:new", "Web:new,old", "Other:also-new,plain"},
+		},
+		{
+			all:        true,
+			ids:        []string{"hidden", "draft", "also-new", "new", "old", "plain"},
+			categories: []string{"Android", "Cloud", "Web"},
+			groups:     []string{"Android:new", "Cloud:draft", "Web:new,old", "Other:hidden,also-new,plain"},
+		},
+	}
+	for i, test := range tests {
+		cat := newCatalog(entries, test.all)
+		var ids []string
+		for _, e := range cat.Codelabs {
+			ids = append(ids, e.ID)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%d: ids = %v; want %v", i, ids, test.ids)
+		}
+		if !reflect.DeepEqual(cat.Categories, test.categories) {
+			t.Errorf("%d: cat.Categories = %v; want %v", i, cat.Categories, test.categories)
+		}
+		var groups []string
+		for _, g := range cat.groups() {
+			var gids []string
+			for _, e := range g.Codelabs {
+				gids = append(gids, e.ID)
+			}
+			groups = append(groups, g.Name+":"+strings.Join(gids, ","))
+		}
+		if !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("%d: groups = %v; want %v", i, groups, test.groups)
+		}
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := &types.ContextMeta{
+		Context: types.Context{Format: "html"},
+		Meta:    types.Meta{ID: "lab", Title: "A <Lab>", Categories: []string{"Web"}},
+	}
+	e, err := newCatalogEntry(dir, filepath.Join(dir, "lab"), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "lab/" {
+		t.Errorf("e.Path = %q; want lab/", e.Path)
+	}
+	if err := writeIndex(dir, newCatalog([]*catalogEntry{e}, false)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, indexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); !strings.Contains(s, `<a href="lab/">A &lt;Lab&gt;</a>`) {
+		t.Errorf("index page does not link to the codelab:\n%s", s)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, catalogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cat catalog
+	if err := json.Unmarshal(b, &cat); err != nil {
+		t.Fatal(err)
+	}
+	if len(cat.Codelabs) != 1 || cat.Codelabs[0].ID != "lab" || cat.Codelabs[0].Path != "lab/" {
+		t.Errorf("catalog = %s; want lab codelab at lab/", b)
+	}
+}
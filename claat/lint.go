@@ -0,0 +1,211 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/googlecodelabs/tools/claat/parser"
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+// Severity levels of lint problems.
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+// lintProblem is a single problem found in a codelab source.
+type lintProblem struct {
+	Source string `json:"source"`
+	types.Pos
+	Severity string `json:"severity"` // lintError or lintWarning
+	Message  string `json:"message"`
+}
+
+// String returns p formatted similarly to reportErr.
+func (p *lintProblem) String() string {
+	sev := "err"
+	if p.Severity == lintWarning {
+		sev = "warn"
+	}
+	loc := p.Source
+	if p.Pos.IsValid() {
+		loc += ":" + p.Pos.String()
+	}
+	return fmt.Sprintf("%s\t%s %s", sev, loc, p.Message)
+}
+
+// cmdLint is the "claat lint ..." subcommand.
+func cmdLint() {
+	if flag.NArg() == 0 {
+		fatalf("Need at least one source. Try '-h' for options.")
+	}
+	type result struct {
+		clab     *types.Codelab
+		problems []*lintProblem
+	}
+	args := unique(flag.Args())
+	res := make([]chan *result, len(args))
+	for i, src := range args {
+		res[i] = make(chan *result, 1)
+		go func(src string, ch chan<- *result) {
+			clab, problems := lintSource(src)
+			ch <- &result{clab, problems}
+		}(src, res[i])
+	}
+
+	problems := []*lintProblem{}
+	ids := make(map[string]string) // codelab ID => source
+	for i, src := range args {
+		r := <-res[i]
+		problems = append(problems, r.problems...)
+		if r.clab == nil || r.clab.ID == "" {
+			continue
+		}
+		if s, ok := ids[r.clab.ID]; ok {
+			problems = append(problems, &lintProblem{
+				Source:   src,
+				Severity: lintError,
+				Message:  fmt.Sprintf("duplicate codelab ID %q, also used by %s", r.clab.ID, s),
+			})
+			continue
+		}
+		ids[r.clab.ID] = src
+	}
+
+	if *jsonOut {
+		b, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			fatalf("%v", err)
+		}
+		os.Stdout.Write(append(b, '\n'))
+	} else {
+		for _, p := range problems {
+			printf("%s", p)
+		}
+	}
+	for _, p := range problems {
+		if p.Severity == lintError {
+			exitMu.Lock()
+			exit = 1
+			exitMu.Unlock()
+			break
+		}
+	}
+}
+
+// lintSource fetches and parses codelab src, checking it and its imports
+// for problems. The returned codelab is nil if src could not be parsed.
+func lintSource(src string) (*types.Codelab, []*lintProblem) {
+	res, err := fetch(src)
+	if err != nil {
+		return nil, []*lintProblem{lintErr(src, err)}
+	}
+	defer res.body.Close()
+	clab, err := parser.Parse(string(res.typ), res.body)
+	if err != nil {
+		return nil, []*lintProblem{lintErr(src, err)}
+	}
+
+	var problems []*lintProblem
+	imp := &importer{name: src, local: res.local}
+	key, err := imp.key()
+	if err != nil {
+		return clab, []*lintProblem{lintErr(src, err)}
+	}
+	for _, st := range clab.Steps {
+		for _, n := range importNodes(st.Content.Nodes) {
+			frag, _, err := slurpFragment(imp, n.URL, []string{key})
+			if err != nil {
+				problems = append(problems, &lintProblem{
+					Source:   src,
+					Pos:      n.Pos(),
+					Severity: lintError,
+					Message:  fmt.Sprintf("import %s: %v", n.URL, err),
+				})
+				continue
+			}
+			n.Content.Nodes = frag
+		}
+	}
+	return clab, append(problems, lintCodelab(src, clab)...)
+}
+
+// lintCodelab checks parsed codelab clab of source src for problems.
+func lintCodelab(src string, clab *types.Codelab) []*lintProblem {
+	var problems []*lintProblem
+	add := func(sev string, pos types.Pos, format string, args ...interface{}) {
+		problems = append(problems, &lintProblem{
+			Source:   src,
+			Pos:      pos,
+			Severity: sev,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if clab.ID == "" {
+		add(lintError, types.Pos{}, "missing codelab ID")
+	}
+	if strings.TrimSpace(clab.Summary) == "" {
+		add(lintWarning, types.Pos{}, "empty summary")
+	}
+	var keys []string
+	for k := range clab.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(lintWarning, types.Pos{}, "unknown metadata key %q", k)
+	}
+	if len(clab.Steps) == 0 {
+		add(lintError, types.Pos{}, "codelab has no steps")
+	}
+
+	for i, st := range clab.Steps {
+		pos := types.Pos{}
+		if len(st.Content.Nodes) > 0 {
+			pos = st.Content.Nodes[0].Pos()
+		}
+		if st.Content.Empty() {
+			add(lintError, pos, "step %d %q is empty", i+1, st.Title)
+		}
+		if st.Duration == 0 {
+			add(lintWarning, pos, "step %d %q has no duration", i+1, st.Title)
+		}
+		for _, img := range imageNodes(st.Content.Nodes) {
+			if strings.TrimSpace(img.Alt) == "" {
+				add(lintWarning, img.Pos(), "image %s has no alt text", img.Src)
+			}
+		}
+	}
+	return problems
+}
+
+// lintErr returns a lint error of source src, made of err.
+// The problem position is set if err has one.
+func lintErr(src string, err error) *lintProblem {
+	p := &lintProblem{Source: src, Severity: lintError, Message: err.Error()}
+	if perr, ok := err.(*parser.PosError); ok {
+		p.Pos = perr.Pos
+		p.Message = perr.Err.Error()
+	}
+	return p
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		in   string
+		want []string // prefixes of problems formatted with String, without the source
+	}{
+		{
+			in: "id: lab\n\nsummary: A lab.\n\n# Title\n\n## Step\n\nDuration: 1:00\n\nText.\n",
+		},
+		{
+			in:   "id: lab\n\nsummary: A lab.\n\n# Title\n\n## Step\n\nDuration: 1:xx\n",
+			want: []string{"err\t:9:1 step \"Step\": unrecognized duration string"},
+		},
+		{
+			in: "flavor: vanilla\n\n# Title\n\n## Step\n\n![](img.png)\n\n## Empty\n",
+			want: []string{
+				"err\t missing codelab ID",
+				"warn\t empty summary",
+				"warn\t unknown metadata key \"flavor\"",
+				"warn\t:7:5 step 1 \"Step\" has no duration",
+				"warn\t:7:5 image img.png has no alt text",
+				"err\t step 2 \"Empty\" is empty",
+				"warn\t step 2 \"Empty\" has no duration",
+			},
+		},
+		{
+			in: "id: lab\n\nsummary: A lab.\n\n# Title\n\n## Step\n\nDuration: 1:00\n\nText.\n\n[[import lab.md]]\n",
+			want: []string{
+				"err\t:13:1 import lab.md: import cycle: ",
+			},
+		},
+		{
+			in:   "---\nid: lab\nsummary: A lab.\nflavor: vanilla\n---\n\n# Title\n\n## Step\n\nDuration: 1:00\n\nText.\n",
+			want: []string{"err\t:4 invalid front matter: field flavor not found"},
+		},
+	}
+	for i, test := range tests {
+		src := filepath.Join(dir, "lab.md")
+		if err := ioutil.WriteFile(src, []byte(test.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, problems := lintSource(src)
+		var got []string
+		for _, p := range problems {
+			p.Source = ""
+			got = append(got, p.String())
+		}
+		ok := len(got) == len(test.want)
+		for j := 0; ok && j < len(got); j++ {
+			ok = strings.HasPrefix(got[j], test.want[j])
+		}
+		if !ok {
+			t.Errorf("%d: lintSource(%q) = %q; want %q", i, test.in, got, test.want)
+		}
+	}
+}
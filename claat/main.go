@@ -26,16 +26,25 @@ import (
 
 	// allow parsers to register themselves
 	_ "github.com/googlecodelabs/tools/claat/parser/gdoc"
+	_ "github.com/googlecodelabs/tools/claat/parser/json"
 	_ "github.com/googlecodelabs/tools/claat/parser/md"
 )
 
 var (
 	authToken = flag.String("auth", "", "OAuth2 Bearer token; alternative credentials override.")
 	output    = flag.String("o", ".", "output directory or '-' for stdout")
-	expenv    = flag.String("e", "web", "codelab environment")
-	tmplout   = flag.String("f", "html", "output format")
+	expenv    = flag.String("e", "web", "comma-separated list of codelab environments")
+	envdir    = flag.String("envdir", "", "codelab dir naming scheme of environments, e.g. {env}/{id}")
+	tmplout   = flag.String("f", "html", "comma-separated list of output formats")
 	prefix    = flag.String("prefix", "../../", "URL prefix for html format")
 	globalGA  = flag.String("ga", "UA-49880327-14", "global Google Analytics account")
+	addr      = flag.String("addr", "localhost:9090", "address for the serve command to listen on")
+	jsonOut   = flag.Bool("json", false, "machine-readable JSON output of the lint command")
+	indexAll  = flag.Bool("all", false, "include draft and hidden codelabs in the index command output")
+	force     = flag.Bool("force", false, "update codelabs even if their source has not changed")
+	redirect  = flag.Bool("redirect", false, "leave redirects in place of codelabs whose ID has changed during update")
+	dryRun    = flag.Bool("dry-run", false, "report what the update command would change, without writing anything")
+	baseURL   = flag.String("baseurl", "", "absolute site URL for sitemap and feed links of the index command")
 	extra     = flag.String("extra", "", "Additional arguments to pass to format templates. JSON object of string,string key values.")
 
 	version string // set by linker -X
@@ -47,19 +56,26 @@ const (
 	imgDirname = "img"
 	// metaFilename is codelab metadata file.
 	metaFilename = "codelab.json"
+	// defaultEnvDir is the -envdir value used when exporting multiple environments.
+	defaultEnvDir = "{id}-{env}"
 	// stdout is a special value for -o cli arg to identify stdout writer.
 	stdout = "-"
 
 	// log report formats
-	reportErr = "err\t%s %v"
-	reportOk  = "ok\t%s"
+	reportErr  = "err\t%s %v"
+	reportOk   = "ok\t%s"
+	reportSkip = "skip\t%s unchanged"
 )
 
 var (
 	// commands contains all valid subcommands, e.g. "claat export".
 	commands = map[string]func(){
+		"convert": cmdConvert,
 		"export":  cmdExport,
+		"index":   cmdIndex,
+		"lint":    cmdLint,
 		"update":  cmdUpdate,
+		"serve":   cmdServe,
 		"help":    usage,
 		"version": func() { fmt.Println(version) },
 	}
@@ -138,7 +154,7 @@ func usage() {
 
 const usageText = `Usage: claat <cmd> [export flags] src [src ...]
 
-Available commands are: export, update, version.
+Available commands are: convert, export, index, lint, serve, update, version.
 
 ## Export command
 
@@ -148,24 +164,64 @@ to the format specified with -f option.
 The following formats are built-in:
 
 - html (Polymer-based app)
+- json (parsed codelab tree, documented in the types package)
 - md (Markdown)
 - offline (plain HTML markup for offline consumption)
 
 To use a custom format, specify a local file path to a Go template file.
 More info on Go templates: https://golang.org/pkg/text/template/.
 
+Multiple formats can be specified as a comma-separated list, e.g. "-f html,md".
+Each source is then fetched and parsed once, and rendered in every format.
+The first format is written to the codelab directory, and so are the others
+unless their main file has already been written, as is the case with
+"html,offline". Such formats are written to a subdirectory named after
+the format instead, e.g. "offline". All formats are recorded in codelab.json
+and re-generated by the update command.
+
+Codelab content tagged with environments is included only if it matches -e,
+which is either a single environment or a boolean expression of environments
+using "and", "or", "not" and parentheses, e.g. "web and not kiosk".
+An environment in the expression is true if the content is tagged with it.
+Content without environment tags is always included.
+
+Similarly to formats, multiple environments can be specified with -e,
+e.g. "-e web,kiosk", each of them being an expression.
+Each environment is written to a separate codelab directory, named according
+to -envdir, where "{id}" and "{env}" are replaced with codelab ID and environment.
+The environment is lowercased, and characters other than letters, digits and '_'
+are replaced with dashes, e.g. "web and not kiosk" becomes "web-and-not-kiosk".
+It defaults to "{id}-{env}" with multiple environments, and "{id}" otherwise.
+Note that -prefix is relative to the codelab directory, which -envdir
+can make deeper, as is the case with "{env}/{id}".
+Each environment directory has its own codelab.json and is re-generated
+by the update command.
+
 Each 'src' can be either a remote HTTP resource or a local file.
 Source formats currently supported are:
 
 - Google Doc (Codelab Format, go/codelab-guide)
 - Markdown
+- JSON (files with .json extension, as exported with "-f json")
 
 When 'src' is a Google Doc, it must be specified as a doc ID,
 omitting https://docs.google.com/... part.
 
 Instead of writing to an output directory, use "-o -" to specify
-stdout. In this case images and metadata are not exported.
+stdout. In this case images and metadata are not exported,
+and only a single format can be specified.
 When writing to a directory, existing files will be overwritten.
+Each codelab is written to a directory named after its ID, which must start
+with a letter or digit, followed by letters, digits, '.', '_' or '-'.
+If IDs of several sources of the same run resolve to the same directory,
+none of them is exported. All sources are parsed before any is written.
+Each codelab directory is written as a whole: the codelab is exported into
+a staging directory next to it, which replaces the original only if
+the export succeeds. A failed export leaves the original directory intact.
+Other files in the directory are kept, while its images are replaced.
+The directory is briefly missing while being replaced; if the program
+is interrupted then, the next export restores the original from its
+.claat-old- backup.
 
 The program exits with non-zero code if at least one src could not be exported.
 
@@ -183,7 +239,33 @@ if codelab ID has changed since last update or export.
 
 In the latter case, where codelab ID has changed, the new directory
 will be placed alongside the old one. In other words, it will have the same ancestor
-as the old one.
+as the old one. A codelab is not updated if its new directory belongs
+to a codelab of a different source, or another codelab of the same run
+resolves to it, in which case neither of them is updated.
+
+Codelabs whose source has not changed since the last update or export
+are skipped. A source is considered unchanged if its modification time
+matches the one stored in codelab.json, or if its parsed content is the same,
+imported fragments included. If the modification time cannot be retrieved,
+the source is fetched and compared by content. Use -force to re-export them regardless.
+Note that changes of remote images referred to by the same URL are not detected.
+
+With -redirect, the old directory of a codelab whose ID has changed
+is replaced with redirects instead of being deleted. Each of its HTML pages,
+including step-N.html pages of the offline format, becomes a stub redirecting
+to the same page in the new directory, using a meta refresh and a canonical link.
+The redirects are also recorded in a redirects.json map of old to new paths,
+relative to the parent of codelab directories, for configuring web servers.
+A directory without HTML pages, e.g. of the md format, is removed instead.
+
+With -dry-run, codelabs are fetched and parsed, but nothing is written
+to or removed from their directories. Instead, a plan is reported for each
+codelab which would be updated, listing its new ID and directory, if changed,
+files which would be created or overwritten, images which would be downloaded
+or removed, directories which would be removed entirely and redirect stubs
+which would be written. Images are not downloaded: their URLs are compared
+with the ones recorded in codelab.json, and an image of a known URL
+is assumed to be unchanged.
 
 While -prefix and -ga can override existing codelab metadata, the other
 arguments have no effect during update.
@@ -191,6 +273,66 @@ arguments have no effect during update.
 The program does not follow symbolic links and exits with non-zero code
 if no metadata found or at least one src could not be updated.
 
+## Index command
+
+Index scans one or more 'src' local directories for codelab.json metadata
+files, recursively, the same way the update command does.
+Current directory is assumed if no 'src' argument is given.
+
+The found codelabs are written to a codelabs.json catalog in -o,
+along with an index.html landing page linking to each of them.
+Codelabs are grouped by category on the page, which can be filtered
+by category, theme, environment and status, and are sorted by their last
+update time, most recent first.
+
+Codelabs with a draft or hidden status are excluded, unless -all is specified.
+With "-o -", the catalog is printed to stdout and no landing page is written.
+
+Index also writes a sitemap.xml and an atom.xml feed of published codelabs,
+which are never draft or hidden. Codelab links are resolved against -baseurl
+or, if it is not specified, the -prefix a codelab was exported with,
+as long as it is an absolute URL. Codelabs without an absolute URL are left out.
+
+## Convert command
+
+Convert takes one or more 'src' documents, usually Google Docs, and converts
+them to Markdown sources, which can be exported the same way as the original
+documents. This is different from the md format of the export command,
+which renders codelab content for reading rather than editing.
+
+Each codelab is written to a codelab.md file of its directory in -o,
+along with its metadata, step durations and images. Imported fragments
+are included in the result. Headers keep their level, except second level
+headers become third level ones, since steps are second level headers in Markdown.
+
+With "-o -", the Markdown is printed to stdout and images are not downloaded.
+
+## Lint command
+
+Lint parses one or more 'src' documents, the same way export does,
+and reports problems without exporting anything.
+
+Errors include parse errors, a missing or duplicate codelab ID,
+empty steps and imports which cannot be resolved.
+Warnings include an empty summary, steps without a duration,
+images without alternative text and unknown Markdown metadata keys.
+
+Problems are printed to stderr, one per line. With -json, they are
+printed to stdout as a JSON array of objects with "source", "line",
+"column", "elem", "severity" and "message" fields instead.
+
+The program exits with non-zero code if at least one error was found.
+
+## Serve command
+
+Serve exports one or more local 'src' Markdown files, just like the export
+command does, and starts an HTTP server on -addr, serving the output directory.
+
+Each 'src', along with local fragments it imports and local images
+it refers to, is watched for changes and re-exported on every save.
+Open HTML pages reload automatically once the new export is written.
+Export errors are reported but do not stop the server.
+
 ## Flags
 
 `
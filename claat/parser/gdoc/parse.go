@@ -116,6 +116,8 @@ type docState struct {
 	lastNode types.Node     // last appended node
 	env      []string       // current enviornment
 	cur      *html.Node     // current HTML node
+	elem     int            // 1-based index of the current top-level body element
+	idElem   int            // elem the codelab ID comes from
 	flags    stateFlag      // current flags
 	stack    []*stackItem   // cur and flags stack
 }
@@ -149,10 +151,13 @@ func (ds *docState) appendNodes(nn ...types.Node) {
 	if ds.step == nil || len(nn) == 0 {
 		return
 	}
-	if len(ds.env) != 0 {
-		for _, n := range nn {
+	for _, n := range nn {
+		if len(ds.env) != 0 {
 			n.MutateEnv(append(n.Env(), ds.env...))
 		}
+		if !n.Pos().IsValid() {
+			n.MutatePos(types.Pos{Elem: ds.elem})
+		}
 	}
 	ds.step.Content.Append(nn...)
 	ds.lastNode = nn[len(nn)-1]
@@ -173,6 +178,7 @@ func parseFragment(doc *html.Node) ([]types.Node, error) {
 	}
 	ds.step = ds.clab.NewStep("fragment")
 	for ds.cur = body.FirstChild; ds.cur != nil; ds.cur = ds.cur.NextSibling {
+		ds.elem++
 		if isComment(ds.css, ds.cur) {
 			// docs export comments at the end of the body
 			break
@@ -200,6 +206,7 @@ func parseDoc(doc *html.Node) (*types.Codelab, error) {
 		css:  style,
 	}
 	for ds.cur = body.FirstChild; ds.cur != nil; ds.cur = ds.cur.NextSibling {
+		ds.elem++
 		if isComment(ds.css, ds.cur) {
 			// docs export comments at the end of the body
 			break
@@ -211,6 +218,7 @@ func parseDoc(doc *html.Node) (*types.Codelab, error) {
 			}
 			if ds.clab.ID == "" {
 				ds.clab.ID = slug(ds.clab.Title)
+				ds.idElem = ds.elem
 			}
 			continue
 		case ds.cur.DataAtom == atom.Table && ds.step == nil:
@@ -227,6 +235,9 @@ func parseDoc(doc *html.Node) (*types.Codelab, error) {
 	}
 
 	finalizeStep(ds.step) // TODO: last ds.step is never finalized in newStep
+	if ds.clab.ID, err = types.NormalizeID(ds.clab.ID); err != nil {
+		return nil, &parser.PosError{Pos: types.Pos{Elem: ds.idElem}, Err: err}
+	}
 	ds.clab.Tags = unique(ds.clab.Tags)
 	sort.Strings(ds.clab.Tags)
 	ds.clab.Duration = int(ds.totdur.Minutes())
@@ -270,6 +281,7 @@ func finalizeStep(s *types.Step) {
 		r := transformNodes(v, l.Nodes[2:len(l.Nodes)-1])
 		if r != nil {
 			r.MutateEnv(l.Env())
+			r.MutatePos(l.Pos())
 			s.Content.Nodes[i] = r
 		}
 	}
@@ -379,6 +391,7 @@ func metaTable(ds *docState) {
 		switch strings.ToLower(stringifyNode(tr.FirstChild, true)) {
 		case "id", "url":
 			ds.clab.ID = s
+			ds.idElem = ds.elem
 		case "author":
 			ds.clab.Author = s
 		case "summary":
@@ -413,6 +426,7 @@ func metaStep(ds *docState) {
 			break
 		}
 		ds.cur = ds.cur.NextSibling
+		ds.elem++
 	}
 	meta := strings.SplitN(strings.TrimSpace(text), metaSep, 2)
 	if len(meta) != 2 {
@@ -656,6 +670,7 @@ func image(ds *docState) types.Node {
 		return nil
 	}
 	n := types.NewImageNode(s)
+	n.Alt = nodeAttr(ds.cur, "alt")
 	n.MaxWidth = styleFloatValue(ds.cur, "width")
 	n.MutateBlock(findBlockParent(ds.cur))
 	return n
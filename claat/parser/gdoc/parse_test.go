@@ -16,6 +16,7 @@ package gdoc
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"reflect"
 	"strings"
@@ -199,6 +200,42 @@ func TestMetaTable(t *testing.T) {
 	}
 }
 
+func TestMetaTableID(t *testing.T) {
+	const markup = `
+	<html>
+	<body>
+		<p class="title">Codelab Title</p>
+		<table>
+			<tr><td>ID</td><td>%s</td></tr>
+		</table>
+	</body>
+	</html>
+	`
+	tests := []struct {
+		in, id string
+		ok     bool
+	}{
+		{"", "", true},
+		{" my lab ", "my-lab", true},
+		{"../../etc", "", false},
+		{"dir/lab", "", false},
+	}
+	for i, test := range tests {
+		clab, err := (&Parser{}).Parse(markupReader(fmt.Sprintf(markup, test.in)))
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%d: Parse(%q) err = %v; want ok %v", i, test.in, err, test.ok)
+			continue
+		}
+		if err == nil && clab.ID != test.id {
+			t.Errorf("%d: ID = %q; want %q", i, clab.ID, test.id)
+		}
+		// the error points at the metadata table
+		if err != nil && !strings.HasPrefix(err.Error(), "element 2: ") {
+			t.Errorf("%d: err = %q; want element 2 position", i, err)
+		}
+	}
+}
+
 func TestParseDoc(t *testing.T) {
 	const markup = `
 	<html><head><style>
@@ -478,4 +515,9 @@ func TestParseFragment(t *testing.T) {
 	if html1 != html2 {
 		t.Errorf("nodes:\n\n%s\nwant:\n\n%s", html1, html2)
 	}
+	for i, n := range nodes {
+		if p := n.Pos(); p.Elem != i+1 {
+			t.Errorf("%d: n.Pos() = %v; want element %d", i, p, i+1)
+		}
+	}
 }
@@ -49,6 +49,7 @@ func squashHeadBlock(nodes []types.Node) (squash, remainder []types.Node) {
 	head := types.NewListNode(hnodes...)
 	head.MutateBlock(true)
 	head.MutateEnv(first.Env())
+	head.MutatePos(first.Pos())
 	return []types.Node{head}, next
 }
 
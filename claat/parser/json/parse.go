@@ -0,0 +1,58 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package json implements a parser of codelabs encoded in JSON,
+// the same way the json output format does.
+// See types.JSONVersion for the schema.
+package json
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/googlecodelabs/tools/claat/parser"
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+func init() {
+	parser.Register("json", &Parser{})
+}
+
+// Parser is a JSON parser.
+type Parser struct {
+}
+
+// Parse parses a codelab encoded in JSON.
+func (p *Parser) Parse(r io.Reader) (*types.Codelab, error) {
+	c := &types.Codelab{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, err
+	}
+	id, err := types.NormalizeID(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.ID = id
+	return c, nil
+}
+
+// ParseFragment parses a codelab fragment encoded in JSON as an array of nodes.
+func (p *Parser) ParseFragment(r io.Reader) ([]types.Node, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return types.UnmarshalNodes(b)
+}
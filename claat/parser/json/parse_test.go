@@ -0,0 +1,129 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+// allNodes returns a node of every type, some of them nested.
+func allNodes() []types.Node {
+	text := types.NewTextNode("bold")
+	text.Bold = true
+	para := types.NewListNode(text, types.NewURLNode("https://example.com", types.NewTextNode("link")))
+	para.MutateBlock(true)
+	para.MutatePos(types.Pos{Line: 3, Column: 1})
+
+	code := types.NewCodeNode("x := 1", false)
+	code.Lang = "go"
+	img := types.NewImageNode("img.png")
+	img.Alt = "an image"
+	img.MaxWidth = 100
+
+	header := types.NewHeaderNode(3, types.NewTextNode("FAQ"))
+	header.MutateType(types.NodeHeaderFAQ)
+	header.MutateEnv([]string{"kiosk", "web"})
+	items := types.NewItemsListNode("1", 2)
+	items.NewItem(types.NewTextNode("one"))
+	items.NewItem(img)
+
+	grid := types.NewGridNode([]*types.GridCell{
+		{Colspan: 2, Rowspan: 1, Content: types.NewListNode(types.NewTextNode("cell"))},
+	})
+	imp := types.NewImportNode("fragment.md")
+	imp.Content.Append(types.NewTextNode("imported"))
+	imp.MutateBlock(true)
+
+	return []types.Node{
+		para,
+		code,
+		types.NewCodeNode("$ ls", true),
+		header,
+		items,
+		grid,
+		types.NewInfoboxNode(types.InfoboxNegative, types.NewTextNode("careful")),
+		types.NewSurveyNode("lab-1", &types.SurveyGroup{Name: "Q?", Options: []string{"a", "b"}}),
+		types.NewButtonNode(true, true, true, types.NewTextNode("Download")),
+		types.NewYouTubeNode("vid"),
+		imp,
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	c := &types.Codelab{
+		Meta: types.Meta{
+			ID:         "lab",
+			Title:      "Lab",
+			Summary:    "A lab.",
+			Duration:   30,
+			Categories: []string{"Web"},
+			Tags:       []string{"kiosk", "web"},
+		},
+		Extra: map[string]string{"flavor": "vanilla"},
+	}
+	st := c.NewStep("Step")
+	st.Duration = 30 * time.Minute
+	st.Tags = []string{"web"}
+	st.Content.Append(allNodes()...)
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := (&Parser{}).Parse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c2, c) {
+		t.Errorf("Parse(%s) = %+v; want %+v", b, c2, c)
+	}
+}
+
+func TestParseFragmentRoundTrip(t *testing.T) {
+	nodes := allNodes()
+	b, err := types.MarshalNodes(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes2, err := (&Parser{}).ParseFragment(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nodes2, nodes) {
+		t.Errorf("ParseFragment(%s) = %+v; want %+v", b, nodes2, nodes)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct{ in, err string }{
+		{`{"version":2}`, "unsupported codelab JSON version 2; want 1"},
+		{`{"version":1,"steps":[{"content":{"type":"table"}}]}`, `step 1: unknown node type "table"`},
+		{`{"version":1,"steps":[{"content":{"type":"text"}}]}`, "step 1: text node where list is expected"},
+		{`{"version":1,"meta":{"id":"../lab"}}`, `invalid codelab ID "../lab": must start with a letter or digit, followed by letters, digits, '.', '_' or '-'`},
+	}
+	for i, test := range tests {
+		_, err := (&Parser{}).Parse(strings.NewReader(test.in))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%d: Parse(%s): %v; want %s", i, test.in, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package md
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/googlecodelabs/tools/claat/parser"
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+// frontMatterDelim starts and ends YAML front matter.
+const frontMatterDelim = "---"
+
+// frontMatter is codelab metadata written as YAML front matter,
+// an alternative to the "key: value" metadata lines.
+type frontMatter struct {
+	ID           string   `yaml:"id"`
+	Summary      string   `yaml:"summary"`
+	Author       string   `yaml:"author"`
+	Duration     int      `yaml:"duration"` // Total duration in minutes, computed from steps if zero
+	Categories   yamlList `yaml:"categories"`
+	Environments yamlList `yaml:"environments"`
+	Tags         yamlList `yaml:"tags"`
+	Status       yamlList `yaml:"status"`
+	Feedback     string   `yaml:"feedback"`
+	GA           string   `yaml:"ga"`
+
+	// The same keys as the metadata lines, metaFeedbackLink and metaAnalyticsAccount.
+	FeedbackLink     string `yaml:"feedback link"`
+	AnalyticsAccount string `yaml:"analytics account"`
+}
+
+// yamlList is a list of strings which can also be written as a single,
+// comma-separated string, just like in the metadata lines.
+type yamlList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler interface.
+func (l *yamlList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var a []string
+	if err := unmarshal(&a); err == nil {
+		*l = a
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return errors.New("expected a string or a list of strings")
+	}
+	*l = strings.Split(s, ",")
+	return nil
+}
+
+// splitFrontMatter separates YAML front matter, delimited by "---" lines at the very beginning of b,
+// from the rest of the document. It returns nil front matter if b has none.
+func splitFrontMatter(b []byte) (fm []byte, rest []byte, err error) {
+	line, i := nextLine(b, 0)
+	if string(bytes.TrimSpace(line)) != frontMatterDelim {
+		return nil, b, nil
+	}
+	start := i
+	for i < len(b) {
+		end := i
+		line, i = nextLine(b, i)
+		if string(bytes.TrimSpace(line)) == frontMatterDelim {
+			return b[start:end], b[i:], nil
+		}
+	}
+	err = errors.New("front matter is missing the closing " + frontMatterDelim)
+	return nil, nil, &parser.PosError{Pos: types.Pos{Line: 1}, Err: err}
+}
+
+// nextLine returns the line of b starting at i, and the index of the line following it.
+func nextLine(b []byte, i int) ([]byte, int) {
+	n := bytes.IndexByte(b[i:], '\n')
+	if n < 0 {
+		return b[i:], len(b)
+	}
+	return b[i : i+n], i + n + 1
+}
+
+// addFrontMatterToCodelab parses YAML front matter b and assigns its values to the codelab fields.
+// It returns an error if b contains unknown keys, or values of the wrong type.
+// Errors are of type *parser.PosError, with lines of the document b starts.
+func addFrontMatterToCodelab(b []byte, c *types.Codelab) error {
+	var fm frontMatter
+	if err := yaml.UnmarshalStrict(b, &fm); err != nil {
+		return yamlError(err)
+	}
+	if fm.Duration < 0 {
+		return frontMatterErrorf(b, "duration", "negative duration %d", fm.Duration)
+	}
+	id, err := types.NormalizeID(fm.ID)
+	if err != nil {
+		return frontMatterErrorf(b, "id", "%v", err)
+	}
+	c.ID = id
+	c.Summary = fm.Summary
+	c.Author = fm.Author
+	c.Duration = fm.Duration
+	c.Categories = append(c.Categories, standardList(fm.Categories)...)
+	c.Tags = append(c.Tags, standardList(fm.Environments)...)
+	c.Tags = append(c.Tags, standardList(fm.Tags)...)
+	if len(fm.Status) > 0 {
+		s := types.LegacyStatus(standardList(fm.Status))
+		c.Status = &s
+	}
+	if c.Feedback, err = eitherKey(b, "feedback", fm.Feedback, metaFeedbackLink, fm.FeedbackLink); err != nil {
+		return err
+	}
+	if c.GA, err = eitherKey(b, "ga", fm.GA, metaAnalyticsAccount, fm.AnalyticsAccount); err != nil {
+		return err
+	}
+	return nil
+}
+
+// eitherKey returns the value of one of two keys of front matter b with the same meaning,
+// v1 of k1 or v2 of k2. It returns an error at the line of k2 if both are set.
+func eitherKey(b []byte, k1, v1, k2, v2 string) (string, error) {
+	if v1 != "" && v2 != "" {
+		return "", frontMatterErrorf(b, k2, "both %q and %q are set", k1, k2)
+	}
+	if v1 != "" {
+		return v1, nil
+	}
+	return v2, nil
+}
+
+// frontMatterErrorf returns an error about key of front matter b,
+// positioned at the line of the key, or the opening delimiter if there is no such line.
+func frontMatterErrorf(b []byte, key, format string, args ...interface{}) error {
+	pos := types.Pos{Line: 1}
+	for i, n := 0, 2; i < len(b); n++ {
+		var line []byte
+		line, i = nextLine(b, i)
+		if k := bytes.SplitN(line, []byte(":"), 2); len(k) == 2 && strings.TrimSpace(string(k[0])) == key {
+			pos.Line = n
+			break
+		}
+	}
+	return &parser.PosError{Pos: pos, Err: fmt.Errorf("invalid front matter: "+format, args...)}
+}
+
+// yamlLineRegexp matches line numbers in errors of the yaml package, e.g. "line 2: ".
+var yamlLineRegexp = regexp.MustCompile(`line (\d+): `)
+
+// yamlError converts err, an error of parsing front matter with the yaml package,
+// into a positioned error. Lines of the yaml package start at the line
+// following the opening delimiter, so they are offset by one.
+// The position is the line of the first problem.
+func yamlError(err error) error {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	if te, ok := err.(*yaml.TypeError); ok {
+		msg = strings.Join(te.Errors, "; ")
+	}
+	pos := types.Pos{Line: 1}
+	msg = yamlLineRegexp.ReplaceAllStringFunc(msg, func(s string) string {
+		n, _ := strconv.Atoi(yamlLineRegexp.FindStringSubmatch(s)[1])
+		if pos.Line == 1 {
+			// the first line is the error position
+			pos.Line = n + 1
+			return ""
+		}
+		return fmt.Sprintf("line %d: ", n+1)
+	})
+	return &parser.PosError{Pos: pos, Err: fmt.Errorf("invalid front matter: %s", msg)}
+}
+
+// standardList trims Unicode spaces from both ends of each element of a and converts them to lowercase,
+// the same way standardSplit does. Empty elements are dropped.
+func standardList(a []string) []string {
+	var res []string
+	for _, s := range a {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"io/ioutil"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -45,6 +46,16 @@ const (
 	metaFeedbackLink     = "feedback link"
 	metaAnalyticsAccount = "analytics account"
 	metaTags             = "tags"
+
+	// Names of the [[name argument]] paragraph directives, other than [[import]].
+	directiveYouTube     = "youtube"
+	directiveEnvironment = "environment"
+	directiveSurvey      = "survey"
+
+	// Possible content of special header nodes in lower case.
+	headerLearn = "what you'll learn"
+	headerCover = "what we've covered"
+	headerFAQ   = "frequently asked questions"
 )
 
 var metadataRegexp = regexp.MustCompile(`(.+?):(.+)`)
@@ -52,6 +63,20 @@ var languageRegexp = regexp.MustCompile(`language-(.+)`)
 var durationHintRegexp = regexp.MustCompile(`(?i)Duration:? (.+)`)
 var durationRegexp = regexp.MustCompile(`(\d+)[:.](\d{2})$`)
 var downloadButtonRegexp = regexp.MustCompile(`^(?i)Download(.+)$`)
+var importRegexp = regexp.MustCompile(`^(?i)\[\[\s*import\s+(\S+)\s*\]\]$`)
+var directiveRegexp = regexp.MustCompile(`^\[\[\s*(\w+)(?:\s+(.*?))?\s*\]\]$`)
+var youtubeRegexp = regexp.MustCompile(`^(?:https?://)?(?:www\.)?(?:youtube\.com/watch\?(?:.*&)?v=|youtu\.be/)([\w-]+)`)
+
+// consoleLangs are language hints of fenced code blocks containing terminal output rather than source code.
+var consoleLangs = map[string]bool{
+	"console":       true,
+	"shell-session": true,
+	"sh-session":    true,
+	"terminal":      true,
+}
+
+// textCleaner replaces "smart quotes" introduced by the Markdown processor with their ascii versions.
+var textCleaner = strings.NewReplacer("\u2018", "'", "\u2019", "'", "\u201C", `"`, "\u201D", `"`)
 
 // init registers this parser so it is available to CLaaT.
 func init() {
@@ -69,15 +94,34 @@ func (p *Parser) Parse(r io.Reader) (*types.Codelab, error) {
 	if err != nil {
 		return nil, err
 	}
-	b = claatMarkdown(b)
-	h := bytes.NewBuffer(b)
+	// Metadata may come as YAML front matter.
+	c := &types.Codelab{}
+	fm, rest, err := splitFrontMatter(b)
+	if err != nil {
+		return nil, err
+	}
+	if fm != nil {
+		if err := addFrontMatterToCodelab(fm, c); err != nil {
+			return nil, err
+		}
+	}
+	// Front matter lines still count when locating nodes.
+	src := newSource(rest, 1+bytes.Count(b[:len(b)-len(rest)], []byte("\n")))
+	h := bytes.NewBuffer(claatMarkdown(src.markup()))
 	// Parse the markup.
-	return parseMarkup(h)
+	return parseMarkup(h, c, src)
 }
 
 // ParseFragment parses a codelab fragment writtet in Markdown.
+// A fragment is the body of a step, without metadata or a title.
 func (p *Parser) ParseFragment(r io.Reader) ([]types.Node, error) {
-	return nil, errors.New("fragment parser not implemented")
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	src := newSource(b, 1)
+	h := bytes.NewBuffer(claatMarkdown(src.markup()))
+	return parseFragment(h, src)
 }
 
 // parserState encapsulates the state of the parser at any given step.
@@ -85,19 +129,82 @@ type parserState struct {
 	tzr *html.Tokenizer
 	c   *types.Codelab
 	t   html.Token
+	// src is the Markdown source of the markup, used to locate nodes. It may be nil.
+	src *source
+	// pos is the source position of the last located text token.
+	pos types.Pos
 
 	currentStep *types.Step
+	// buf is a stack of node buffers used while parsing element subtrees, innermost last.
+	buf []*types.ListNode
+	// env is the current environment, set with the [[environment]] directive.
+	env []string
+	// survey is the last used survey ID number.
+	survey int
 }
 
 // emit accepts a node, and either writes the node directly to the current step, or writes the node to the node buffer.
+// Nodes without a position get the one of their first child which has it, or the position of the last located text.
 func (ps *parserState) emit(n types.Node) {
+	if !n.Pos().IsValid() {
+		p := firstPos(n)
+		if !p.IsValid() {
+			p = ps.pos
+		}
+		n.MutatePos(p)
+	}
+	if len(ps.buf) > 0 {
+		ps.buf[len(ps.buf)-1].Append(n)
+		return
+	}
+	if len(ps.env) != 0 {
+		n.MutateEnv(append(n.Env(), ps.env...))
+	}
 	ps.currentStep.Content.Append(n)
 }
 
 // advance moves the tokenizer to the next token and updates the token convenience variable.
+// Block markers of the Markdown source are skipped, moving the current position to the block start,
+// and text tokens are located in the block, updating the current position.
 func (ps *parserState) advance() {
 	ps.tzr.Next()
 	ps.t = ps.tzr.Token()
+	for ps.t.Type == html.CommentToken && isMarker(ps.t.Data) {
+		if p := ps.src.seek(ps.t.Data); p.IsValid() {
+			ps.pos = p
+		}
+		// Skip the blank line following the marker too.
+		ps.tzr.Next()
+		ps.t = ps.tzr.Token()
+		if ps.t.Type == html.TextToken && strings.TrimSpace(ps.t.Data) == "" {
+			ps.tzr.Next()
+			ps.t = ps.tzr.Token()
+		}
+	}
+	if ps.t.Type == html.TextToken {
+		if p := ps.src.locate(ps.t.Data); p.IsValid() {
+			ps.pos = p
+		}
+	}
+}
+
+// errorf returns a parser error at the current position.
+func (ps *parserState) errorf(format string, args ...interface{}) error {
+	return &parser.PosError{Pos: ps.pos, Err: fmt.Errorf(format, args...)}
+}
+
+// lastNode returns the last non-empty node written to the node buffer, or to the current step, or nil if there is none.
+func (ps *parserState) lastNode() types.Node {
+	nodes := ps.currentStep.Content.Nodes
+	if len(ps.buf) > 0 {
+		nodes = ps.buf[len(ps.buf)-1].Nodes
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if !nodes[i].Empty() {
+			return nodes[i]
+		}
+	}
+	return nil
 }
 
 // multiAdvance is a convenience method for repeatedly advancing.
@@ -128,11 +235,13 @@ func claatMarkdown(b []byte) []byte {
 }
 
 // parseMarkup accepts an io.Reader to markup created by the Devsite Markdown parser. It returns a pointer to a codelab object, or an error if one occurs.
-func parseMarkup(markup io.Reader) (*types.Codelab, error) {
+// Metadata found in the markup is added to c. Nodes and errors are located in src, which may be nil.
+func parseMarkup(markup io.Reader, c *types.Codelab, src *source) (*types.Codelab, error) {
 	// Avoid global vars by encapsulating state.
 	ps := parserState{
 		tzr: html.NewTokenizer(markup),
-		c:   &types.Codelab{},
+		c:   c,
+		src: src,
 	}
 
 	var inStepTitle bool
@@ -162,7 +271,11 @@ func parseMarkup(markup io.Reader) (*types.Codelab, error) {
 			ps.advance()
 			// Emit a step object.
 			ps.currentStep = ps.c.NewStep(stepTitle)
-			parseStep(&ps)
+			ps.env = nil
+			if err := parseStep(&ps); err != nil {
+				return nil, err
+			}
+			finalizeStep(ps.currentStep)
 
 			// If we just finished parsing a step or the title, we are left possibly pointing to the opening
 			// <h2> of another step. Update the flag accordingly.
@@ -183,6 +296,31 @@ func parseMarkup(markup io.Reader) (*types.Codelab, error) {
 	return ps.c, nil
 }
 
+// parseFragment accepts an io.Reader to markup of a codelab fragment. It returns the nodes of the fragment,
+// or an error if one occurs. Nodes are located in src, which may be nil.
+func parseFragment(markup io.Reader, src *source) ([]types.Node, error) {
+	ps := parserState{
+		tzr: html.NewTokenizer(markup),
+		c:   &types.Codelab{},
+		src: src,
+	}
+	ps.currentStep = ps.c.NewStep("fragment")
+
+	for ps.advance(); ps.t.Type != html.ErrorToken; ps.advance() {
+		parseContent(&ps)
+		// Fragments have no steps, so a second level header is just a regular header.
+		if ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.H2 {
+			handleHeader(&ps)
+		}
+	}
+	// If not EOF, an error occurred in tokenization.
+	if err := ps.tzr.Err(); err != io.EOF {
+		return nil, err
+	}
+	finalizeStep(ps.currentStep)
+	return ps.currentStep.Content.Nodes, nil
+}
+
 // parseMetadata handles the metadata section preceding a codelab.
 // It assumes the tokenizer is pointing to the first <p>/.
 // It returns any errors it encounters, and leaves the tokenizer pointing at the <h1>
@@ -197,10 +335,17 @@ func parseMetadata(ps *parserState) error {
 			// Split the keys from values.
 			s := metadataRegexp.FindStringSubmatch(ps.t.Data)
 			if len(s) != 3 {
-				return fmt.Errorf("invalid metadata format: %v", s)
+				return ps.errorf("invalid metadata format: %q", ps.t.Data)
 			}
 			k := strings.ToLower(strings.TrimSpace(s[1]))
 			v := strings.TrimSpace(s[2])
+			if k == metaID {
+				id, err := types.NormalizeID(v)
+				if err != nil {
+					return ps.errorf("%v", err)
+				}
+				v = id
+			}
 			m[k] = v
 		}
 	}
@@ -223,11 +368,51 @@ func parseStep(ps *parserState) error {
 		}
 	}
 
+	parseContent(ps)
+	return nil
+}
+
+// parseContent handles the content of a step or a fragment, starting at the token the tokenizer is pointing to.
+// It leaves the tokenizer pointing at the <h2> starting the next step, or at io.EOF.
+func parseContent(ps *parserState) {
+	parseNodes(ps, func() bool {
+		return ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.H2
+	})
+}
+
+// parseSubtree handles the content of the element the tokenizer is pointing to, up to its end tag.
+// Instead of being emitted, the resulting nodes are returned. It leaves the tokenizer pointing at the end tag.
+func parseSubtree(ps *parserState) []types.Node {
+	a := ps.t.DataAtom
+	ps.advance()
+	return parseUntil(ps, a)
+}
+
+// parseUntil handles content tokens, starting at the token the tokenizer is pointing to, up to the end tag a.
+// Instead of being emitted, the resulting nodes are returned. It leaves the tokenizer pointing at the end tag.
+func parseUntil(ps *parserState, a atom.Atom) []types.Node {
+	return collectNodes(ps, func() bool {
+		return ps.t.Type == html.EndTagToken && ps.t.DataAtom == a
+	})
+}
+
+// collectNodes is like parseNodes, except the resulting nodes are returned instead of being emitted.
+func collectNodes(ps *parserState, done func() bool) []types.Node {
+	l := types.NewListNode()
+	ps.buf = append(ps.buf, l)
+	parseNodes(ps, done)
+	ps.buf = ps.buf[:len(ps.buf)-1]
+	return l.Nodes
+}
+
+// parseNodes handles content tokens in order, starting at the token the tokenizer is pointing to.
+// It stops on an error or when done returns true, leaving the tokenizer pointing at that token.
+func parseNodes(ps *parserState, done func() bool) {
 	// Track text styling settings.
 	var bold, italic bool
 
-	// Continue reading tokens in order, stopping on an error or the beginning of another step.
-	for ; ps.t.Type != html.ErrorToken && !(ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.H2); ps.advance() {
+	// Continue reading tokens in order, stopping on an error or the end of the content.
+	for ; ps.t.Type != html.ErrorToken && !done(); ps.advance() {
 
 		// Handle <h3> through <h6>.
 		if ps.t.Type == html.StartTagToken && (ps.t.DataAtom == atom.H3 || ps.t.DataAtom == atom.H4 || ps.t.DataAtom == atom.H5 || ps.t.DataAtom == atom.H6) {
@@ -245,10 +430,14 @@ func parseStep(ps *parserState) error {
 		if ps.t.Type == html.StartTagToken && (ps.t.DataAtom == atom.Ul || ps.t.DataAtom == atom.Ol) {
 			handleList(ps)
 		}
-		// Handle <dt>.
-		if ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.Dt {
+		// Handle <dl>.
+		if ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.Dl {
 			handleInfobox(ps)
 		}
+		// Handle <table>.
+		if ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.Table {
+			handleTable(ps)
+		}
 		// Handle <em>.
 		if ps.t.DataAtom == atom.Em {
 			italic = ps.t.Type == html.StartTagToken
@@ -265,6 +454,18 @@ func parseStep(ps *parserState) error {
 		if ps.t.DataAtom == atom.A && ps.t.Type == html.StartTagToken {
 			handleLink(ps)
 		}
+		// Handle [[import]] and other directives. They end with the enclosing block, which may also end the content.
+		if ps.t.Type == html.TextToken && (isImportStart(ps.t.Data) || isDirectiveStart(ps.t.Data)) {
+			if isImportStart(ps.t.Data) {
+				handleImport(ps)
+			} else {
+				handleDirective(ps)
+			}
+			if done() {
+				break
+			}
+			continue
+		}
 		// Handle text.
 		if ps.t.Type == html.TextToken {
 			n := newBreaklessTextNode(ps.t.Data)
@@ -273,7 +474,6 @@ func parseStep(ps *parserState) error {
 			ps.emit(n)
 		}
 	}
-	return nil
 }
 
 // handleCodelabTitle takes care of setting the title for the codelab. It assumes the tokenizer is pointing to <h1>.
@@ -314,12 +514,14 @@ func handleDurationHint(ps *parserState) error {
 	}
 	s := durationHintRegexp.FindStringSubmatch(ps.t.Data)
 	// This is possibly not a duration string, so bail out if we don't have strong indications that it is.
+	// The tokenizer is left pointing at the text, so that it is parsed as regular content.
+	if len(s) < 2 {
+		return nil
+	}
 	var err error
-	if len(s) >= 2 {
-		ps.currentStep.Duration, err = processDuration(s[1])
-		if err != nil {
-			return err
-		}
+	ps.currentStep.Duration, err = processDuration(s[1])
+	if err != nil {
+		return ps.errorf("step %q: %v", ps.currentStep.Title, err)
 	}
 	ps.advance()
 	// Now we're on the closing p tag of the duration string.
@@ -329,7 +531,59 @@ func handleDurationHint(ps *parserState) error {
 // finalizeCodelab takes care of all work that should be performed after the entire input is parsed.
 // It takes a pointer to a parserState object, and acts on the codelab referenced in it.
 func finalizeCodelab(ps *parserState) {
-	computeTotalDuration(ps.c)
+	// Duration may have been set explicitly in the front matter.
+	if ps.c.Duration == 0 {
+		computeTotalDuration(ps.c)
+	}
+	ps.c.Tags = unique(ps.c.Tags)
+	sort.Strings(ps.c.Tags)
+}
+
+// finalizeStep takes care of all work that should be performed after an entire step, or a fragment, is parsed.
+func finalizeStep(s *types.Step) {
+	s.Tags = unique(s.Tags)
+	sort.Strings(s.Tags)
+	s.Content.Nodes = finalizeSurveys(s.Content.Nodes)
+}
+
+// finalizeSurveys completes the surveys started with the [[survey]] directive. Each header directly following
+// the directive, and itself followed by a list, is a survey question, and the list items are its options.
+// It returns nodes with the questions and options replaced by the survey. Surveys without questions are removed.
+func finalizeSurveys(nodes []types.Node) []types.Node {
+	// next returns the index of the next non-empty node, starting at i.
+	next := func(i int) int {
+		for ; i < len(nodes) && nodes[i].Empty(); i++ {
+		}
+		return i
+	}
+	var res []types.Node
+	for i := 0; i < len(nodes); i++ {
+		sn, ok := nodes[i].(*types.SurveyNode)
+		if !ok || len(sn.Groups) > 0 {
+			res = append(res, nodes[i])
+			continue
+		}
+		for {
+			h := next(i + 1)
+			if h == len(nodes) || !types.IsHeader(nodes[h].Type()) {
+				break
+			}
+			l := next(h + 1)
+			if l == len(nodes) || !types.IsItemsList(nodes[l].Type()) {
+				break
+			}
+			g := &types.SurveyGroup{Name: strings.TrimSpace(stringifyNodes(nodes[h].(*types.HeaderNode).Content.Nodes))}
+			for _, item := range nodes[l].(*types.ItemsListNode).Items {
+				g.Options = append(g.Options, strings.TrimSpace(stringifyNodes(item.Nodes)))
+			}
+			sn.Groups = append(sn.Groups, g)
+			i = l
+		}
+		if len(sn.Groups) > 0 {
+			res = append(res, sn)
+		}
+	}
+	return res
 }
 
 // computeTotalDuration computes the total duration for a codelab by summing the duration of each step.
@@ -340,78 +594,177 @@ func computeTotalDuration(c *types.Codelab) {
 	}
 }
 
-// handleHeader handles header tags, h3-h6. It assumes the tokenizer is pointing to <h_>.
-// It takes a pointer to a parserState object.
+// handleHeader handles header tags, h2-h6. It assumes the tokenizer is pointing to <h_>, and leaves it pointing
+// at the closing tag. Headers of checklists and FAQ are given their special types.
 func handleHeader(ps *parserState) {
 	var l int
 	switch ps.t.DataAtom {
+	case atom.H2:
+		l = 2
 	case atom.H3:
 		l = 3
-		break
 	case atom.H4:
 		l = 4
-		break
 	case atom.H5:
 		l = 5
-		break
 	case atom.H6:
 		l = 6
-		break
 	}
-	ps.advance()
-	n := types.NewHeaderNode(l, newBreaklessTextNode(ps.t.Data))
+	nodes := parseSubtree(ps)
+	if len(nodes) == 0 {
+		return
+	}
+	// A header at the top level ends the current environment.
+	if len(ps.buf) == 0 {
+		ps.env = nil
+	}
+	n := types.NewHeaderNode(l, nodes...)
+	switch strings.ToLower(strings.TrimSpace(textCleaner.Replace(stringifyNodes(nodes)))) {
+	case headerLearn, headerCover:
+		n.MutateType(types.NodeHeaderCheck)
+	case headerFAQ:
+		n.MutateType(types.NodeHeaderFAQ)
+	}
 	ps.emit(n)
-	ps.advance() // Now we are on the closing tag.
 }
 
-// handleList handles both ordered and unordered lists. It assumes the tokenizer is pointing to <ul> or <ol>.
-// It takes a pointer to a parserState object.
+// handleList handles both ordered and unordered lists. It assumes the tokenizer is pointing to <ul> or <ol>,
+// and leaves it pointing at the matching </ul> or </ol>.
+// Each list item is parsed as regular content, so it may contain inline formatting and nested lists.
 func handleList(ps *parserState) {
+	a := ps.t.DataAtom
 	start := 0
-	if ps.t.DataAtom == atom.Ol {
+	if a == atom.Ol {
 		start = 1
 	}
 	iln := types.NewItemsListNode("", start)
 
-	for ps.advance(); ps.t.Type != html.ErrorToken && !(ps.t.Type == html.EndTagToken && (ps.t.DataAtom == atom.Ul || ps.t.DataAtom == atom.Ol)); ps.advance() {
+	for ps.advance(); ps.t.Type != html.ErrorToken && !(ps.t.Type == html.EndTagToken && ps.t.DataAtom == a); ps.advance() {
 		if ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.Li {
-			ps.advance()
-			iln.NewItem(newBreaklessTextNode(ps.t.Data))
+			if nodes := parseSubtree(ps); len(nodes) > 0 {
+				iln.NewItem(nodes...)
+			}
+		}
+	}
+	// Lists following checklist or FAQ headers are special too.
+	if last := ps.lastNode(); last != nil {
+		switch last.Type() {
+		case types.NodeHeaderCheck:
+			iln.MutateType(types.NodeItemsCheck)
+		case types.NodeHeaderFAQ:
+			iln.MutateType(types.NodeItemsFAQ)
 		}
 	}
 	ps.emit(iln)
 }
 
-// handleInfobox handles the colored call-out boxes in codelabs. It assumes the tokenizer is pointing to <dt>.
+// handleInfobox handles the colored call-out boxes in codelabs, written as definition lists. It assumes the tokenizer
+// is pointing to <dl>, and leaves it pointing at </dl>. Each <dt> sets the kind of infobox, and the content of
+// the following <dd> elements is parsed as regular content, see parseDefinition.
 func handleInfobox(ps *parserState) {
-	// Advance to <dt>'s text content.
-	ps.advance()
-	// Deduce the kind of infobox.
+	var ib *types.InfoboxNode
 	var kind types.InfoboxKind
-	sentiment := strings.ToLower(ps.t.Data)
-	if sentiment == "positive" {
-		kind = types.InfoboxPositive
-	} else if sentiment == "negative" {
-		kind = types.InfoboxNegative
+	for ps.advance(); ps.t.Type != html.ErrorToken && !(ps.t.Type == html.EndTagToken && ps.t.DataAtom == atom.Dl); ps.advance() {
+		if ps.t.Type != html.StartTagToken {
+			continue
+		}
+		switch ps.t.DataAtom {
+		case atom.Dt:
+			// Deduce the kind of infobox.
+			sentiment := strings.ToLower(strings.TrimSpace(stringifyNodes(parseSubtree(ps))))
+			if sentiment == "positive" {
+				kind = types.InfoboxPositive
+			} else if sentiment == "negative" {
+				kind = types.InfoboxNegative
+			}
+			ib = nil
+		case atom.Dd:
+			nodes := parseDefinition(ps)
+			// Subsequent definitions of the same term belong to the same infobox.
+			if ib != nil {
+				ib.Content.Append(nodes...)
+				continue
+			}
+			ib = types.NewInfoboxNode(kind, nodes...)
+			ps.emit(ib)
+		}
 	}
+}
 
-	// Advance to <dd>'s text content.
-	ps.multiAdvance(4)
-	n := types.NewInfoboxNode(kind, newBreaklessTextNode(ps.t.Data))
-	ps.emit(n)
+// parseDefinition handles the content of the <dd> element the tokenizer is pointing to, up to </dd>.
+// Instead of being emitted, the resulting nodes are returned. Paragraphs of a multi-paragraph definition
+// are returned as separate blocks, so that they are not run together.
+func parseDefinition(ps *parserState) []types.Node {
+	end := func() bool {
+		return ps.t.Type == html.EndTagToken && ps.t.DataAtom == atom.Dd
+	}
+	var nodes []types.Node
+	for ps.advance(); ps.t.Type != html.ErrorToken && !end(); {
+		if ps.t.Type != html.StartTagToken || ps.t.DataAtom != atom.P {
+			nodes = append(nodes, collectNodes(ps, func() bool {
+				return end() || (ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.P)
+			})...)
+			continue
+		}
+		if p := parseSubtree(ps); len(p) > 0 {
+			l := types.NewListNode(p...)
+			l.MutateBlock(true)
+			nodes = append(nodes, l)
+		}
+		// Move past </p>.
+		ps.advance()
+	}
+	return nodes
+}
 
-	// Advance to </dd>.
-	ps.advance()
+// handleTable handles tables, turning them into a grid. It assumes the tokenizer is pointing to <table>,
+// and leaves it pointing at </table>. Cell content is parsed as regular content, and header cell text is made bold.
+func handleTable(ps *parserState) {
+	var rows [][]*types.GridCell
+	for ps.advance(); ps.t.Type != html.ErrorToken && !(ps.t.Type == html.EndTagToken && ps.t.DataAtom == atom.Table); ps.advance() {
+		if ps.t.Type != html.StartTagToken {
+			continue
+		}
+		switch ps.t.DataAtom {
+		case atom.Tr:
+			rows = append(rows, nil)
+		case atom.Th, atom.Td:
+			if len(rows) == 0 {
+				rows = append(rows, nil)
+			}
+			th := ps.t.DataAtom == atom.Th
+			nodes := parseSubtree(ps)
+			if th {
+				for _, n := range nodes {
+					if t, ok := n.(*types.TextNode); ok {
+						t.Bold = true
+					}
+				}
+			}
+			cell := &types.GridCell{
+				Colspan: 1,
+				Rowspan: 1,
+				Content: types.NewListNode(nodes...),
+			}
+			rows[len(rows)-1] = append(rows[len(rows)-1], cell)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	ps.emit(types.NewGridNode(rows...))
 }
 
 // handleImage handles <img> tags. It assumes the tokenizer is pointing to the <img> tag itself.
 func handleImage(ps *parserState) {
-	for _, v := range ps.t.Attr {
-		if v.Key == "src" {
-			ps.emit(types.NewImageNode(v.Val))
-			break
-		}
+	src := attr(ps.t, "src")
+	if src == "" {
+		return
 	}
+	n := types.NewImageNode(src)
+	n.Alt = attr(ps.t, "alt")
+	n.MutatePos(ps.src.locate(src))
+	ps.emit(n)
 }
 
 // handleLink handles links and download buttons, both of which appear as <a> elements.
@@ -428,8 +781,9 @@ func handleLink(ps *parserState) {
 	// Check for the download button case.
 	s := downloadButtonRegexp.FindStringSubmatch(ps.t.Data)
 	if len(s) >= 2 {
-		// It's a button, emit a button element with all the pretty styling enabled.
-		ps.emit(types.NewButtonNode(true, true, true, newBreaklessTextNode(s[1])))
+		// It's a button, emit a button element with all the pretty styling enabled, linking to href.
+		btn := types.NewButtonNode(true, true, true, newBreaklessTextNode(s[1]))
+		ps.emit(types.NewURLNode(href, btn))
 	} else {
 		// It's not a button, emit an ordinary link.
 		ps.emit(types.NewURLNode(href, newBreaklessTextNode(ps.t.Data)))
@@ -439,6 +793,7 @@ func handleLink(ps *parserState) {
 }
 
 // handleFencedCodeBlock handles all code elements wrapped in ```s.
+// Blocks with one of consoleLangs language hints are terminal output.
 // It assumes the tokenizer is pointing to the <pre> tag establishing the block.
 func handleFencedCodeBlock(ps *parserState) {
 	// Advance to <code>.
@@ -456,13 +811,18 @@ func handleFencedCodeBlock(ps *parserState) {
 	}
 	// Advance to text content.
 	ps.advance()
-	n := types.NewCodeNode(ps.t.Data, false)
-	n.Lang = lang
-	ps.emit(n)
+	if consoleLangs[strings.ToLower(lang)] {
+		ps.emit(types.NewCodeNode(ps.t.Data, true))
+	} else {
+		n := types.NewCodeNode(ps.t.Data, false)
+		n.Lang = lang
+		ps.emit(n)
+	}
 	// Advance to </pre>.
 	ps.multiAdvance(2)
 }
 
+// handleInlineCodeBlock handles code spans wrapped in single backticks.
 // It assumes the tokenizer is pointing to the <code> tag establishing the block.
 func handleInlineCodeBlock(ps *parserState) {
 	// Advance to text content.
@@ -475,6 +835,139 @@ func handleInlineCodeBlock(ps *parserState) {
 	ps.advance()
 }
 
+// isImportStart reports whether text s looks like the beginning of an [[import]] directive.
+func isImportStart(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.HasPrefix(s, "[[import")
+}
+
+// handleImport handles [[import URL]] directives, which must be in a paragraph by themselves.
+// The URL may also be written as a code span or a link, which keeps Markdown from transforming its characters.
+// It assumes the tokenizer is pointing to the text starting the directive, and leaves it pointing at the end tag
+// of the enclosing block, usually </p>. If the block isn't a well-formed directive, its text is emitted as is.
+func handleImport(ps *parserState) {
+	v := directiveText(ps)
+	s := importRegexp.FindStringSubmatch(v)
+	if len(s) != 2 {
+		ps.emit(newBreaklessTextNode(v))
+		return
+	}
+	ps.emit(types.NewImportNode(s[1]))
+}
+
+// isDirectiveStart reports whether text s looks like the beginning of a directive other than [[import]].
+func isDirectiveStart(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, name := range []string{directiveYouTube, directiveEnvironment, directiveSurvey} {
+		if strings.HasPrefix(s, "[["+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDirective handles [[name argument]] directives other than [[import]], the same way handleImport does.
+// If the block isn't a well-formed directive, its text is emitted as is.
+func handleDirective(ps *parserState) {
+	v := directiveText(ps)
+	s := directiveRegexp.FindStringSubmatch(v)
+	if len(s) != 3 || !applyDirective(ps, strings.ToLower(s[1]), s[2]) {
+		ps.emit(newBreaklessTextNode(v))
+	}
+}
+
+// directiveText returns the text of a directive, up to the end tag of the enclosing block, where it leaves
+// the tokenizer pointing. Links contribute their target rather than their text.
+func directiveText(ps *parserState) string {
+	var buf bytes.Buffer
+	for ; ps.t.Type != html.ErrorToken && !(ps.t.Type == html.EndTagToken && !isInlineAtom(ps.t.DataAtom)); ps.advance() {
+		switch {
+		case ps.t.Type == html.TextToken:
+			buf.WriteString(ps.t.Data)
+		case ps.t.Type == html.StartTagToken && ps.t.DataAtom == atom.A:
+			// Use the link target rather than its text.
+			for _, v := range ps.t.Attr {
+				if v.Key == "href" {
+					buf.WriteString(v.Val)
+				}
+			}
+			for ps.advance(); ps.t.Type != html.ErrorToken && !(ps.t.Type == html.EndTagToken && ps.t.DataAtom == atom.A); ps.advance() {
+			}
+		}
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// isInlineAtom reports whether a is one of the inline elements produced by the Markdown processor.
+func isInlineAtom(a atom.Atom) bool {
+	switch a {
+	case atom.A, atom.Code, atom.Em, atom.Strong, atom.Img:
+		return true
+	}
+	return false
+}
+
+// applyDirective executes the directive name with argument arg.
+// It returns false if the directive is unknown or its argument is invalid.
+func applyDirective(ps *parserState, name, arg string) bool {
+	switch name {
+	case directiveYouTube:
+		// [[youtube VIDEO_ID]] or [[youtube https://www.youtube.com/watch?v=VIDEO_ID]]
+		vid := arg
+		if s := youtubeRegexp.FindStringSubmatch(arg); len(s) == 2 {
+			vid = s[1]
+		}
+		if vid == "" || strings.ContainsAny(vid, " \t/?") {
+			return false
+		}
+		n := types.NewYouTubeNode(vid)
+		n.MutateBlock(true)
+		ps.emit(n)
+	case directiveEnvironment:
+		// [[environment web, kiosk]] applies to the following content, up to the next header.
+		// A header directly preceding the directive is also included.
+		ps.env = unique(standardSplit(arg))
+		if len(ps.env) == 1 && ps.env[0] == "" {
+			ps.env = nil
+		}
+		ps.currentStep.Tags = append(ps.currentStep.Tags, ps.env...)
+		ps.c.Tags = append(ps.c.Tags, ps.env...)
+		if last := ps.lastNode(); last != nil && types.IsHeader(last.Type()) && len(ps.buf) == 0 {
+			last.MutateEnv(ps.env)
+		}
+	case directiveSurvey:
+		// [[survey]] is completed with the questions following it in finalizeSurveys.
+		// Fragments have no codelab ID, so their surveys are left for the importing codelab to name.
+		var id string
+		if ps.c.ID != "" {
+			ps.survey++
+			id = fmt.Sprintf("%s-%d", ps.c.ID, ps.survey)
+		}
+		ps.emit(types.NewSurveyNode(id))
+	default:
+		return false
+	}
+	return true
+}
+
+// stringifyNodes returns the text content of nodes, including their children.
+func stringifyNodes(nodes []types.Node) string {
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *types.TextNode:
+			buf.WriteString(n.Value)
+		case *types.ListNode:
+			buf.WriteString(stringifyNodes(n.Nodes))
+		case *types.URLNode:
+			buf.WriteString(stringifyNodes(n.Content.Nodes))
+		case *types.ButtonNode:
+			buf.WriteString(stringifyNodes(n.Content.Nodes))
+		}
+	}
+	return buf.String()
+}
+
 // standardSplit takes a string, splits it along a comma delimiter, then on each fragment, trims Unicode spaces
 // from both ends and converts them to lowercase. It returns a slice of the processed strings.
 func standardSplit(s string) []string {
@@ -528,9 +1021,36 @@ func addMetadataToCodelab(m map[string]string, c *types.Codelab) {
 			c.Tags = append(c.Tags, standardSplit(v)...)
 			break
 		default:
-			break
+			if c.Extra == nil {
+				c.Extra = make(map[string]string)
+			}
+			c.Extra[k] = v
+		}
+	}
+}
+
+// attr returns the value of t's attribute key, or an empty string if t has no such attribute.
+func attr(t html.Token, key string) string {
+	for _, a := range t.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// unique removes duplicates from slice.
+// Original arg is not modified. Elements order is preserved.
+func unique(a []string) []string {
+	seen := make(map[string]bool, len(a))
+	res := make([]string, 0, len(a))
+	for _, s := range a {
+		if !seen[s] {
+			res = append(res, s)
+			seen[s] = true
 		}
 	}
+	return res
 }
 
 // newBreaklessTextNode accepts a string, and constructs a new TextNode containing the string,
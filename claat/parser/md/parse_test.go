@@ -24,6 +24,7 @@ import (
 	"github.com/googlecodelabs/tools/claat/types"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 func buildParserWithStep(markup string) *parserState {
@@ -224,3 +225,612 @@ func TestNewBreaklessTextNode(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFragment(t *testing.T) {
+	const in = `Some text
+
+[[import shared/setup.md]]
+
+[[import ` + "`https://example.com/a--b.md`" + `]]
+
+[[Import [cleanup](https://example.com/cleanup.md)]]
+
+## Second level header
+`
+	nodes, err := (&Parser{}).ParseFragment(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imports []string
+	var header *types.HeaderNode
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *types.ImportNode:
+			imports = append(imports, n.URL)
+		case *types.HeaderNode:
+			header = n
+		}
+	}
+	want := []string{"shared/setup.md", "https://example.com/a--b.md", "https://example.com/cleanup.md"}
+	if !reflect.DeepEqual(imports, want) {
+		t.Errorf("imports = %v; want %v", imports, want)
+	}
+	if header == nil || header.Level != 2 {
+		t.Errorf("header = %+v; want level 2 header", header)
+	}
+	if len(nodes) == 0 || nodes[0].Type() != types.NodeText {
+		t.Errorf("first node = %+v; want text", nodes)
+	}
+}
+
+func TestHandleImportMalformed(t *testing.T) {
+	ps := buildParserWithStep("<p>[[import a b]]</p>")
+	ps.multiAdvance(2)
+	handleImport(ps)
+	nodes := ps.currentStep.Content.Nodes
+	if len(nodes) != 1 || nodes[0].Type() != types.NodeText {
+		t.Fatalf("nodes = %+v; want one text node", nodes)
+	}
+	if v := nodes[0].(*types.TextNode).Value; v != "[[import a b]]" {
+		t.Errorf("text = %q; want %q", v, "[[import a b]]")
+	}
+}
+
+func TestParseStepImport(t *testing.T) {
+	const in = `id: lab
+
+# Title
+
+## Step
+
+[[import shared/setup.md]]
+
+Duration: 5:00
+`
+	clab, err := (&Parser{}).Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := clab.Steps[0]
+	if step.Duration != 0 {
+		t.Errorf("step.Duration = %v; want 0", step.Duration)
+	}
+	nodes := step.Content.Nodes
+	if len(nodes) == 0 {
+		t.Fatal("step has no content")
+	}
+	imp, ok := nodes[0].(*types.ImportNode)
+	if !ok || imp.URL != "shared/setup.md" {
+		t.Errorf("nodes[0] = %#v; want import of shared/setup.md", nodes[0])
+	}
+}
+
+func TestHandleDirective(t *testing.T) {
+	tests := []struct {
+		in   string
+		want types.NodeType
+	}{
+		{"<p>[[youtube abc-123]]</p>", types.NodeYouTube},
+		{"<p>[[Survey]]</p>", types.NodeSurvey},
+		{"<p>[[youtube <a href=\"https://youtu.be/abc\">video</a>]]</p>", types.NodeYouTube},
+		{"<p>[[youtube a b]]</p>", types.NodeText},
+		{"<p>[[youtube]]</p>", types.NodeText},
+	}
+	for i, tc := range tests {
+		ps := buildParserWithStep(tc.in)
+		ps.multiAdvance(2)
+		handleDirective(ps)
+		nodes := ps.currentStep.Content.Nodes
+		if len(nodes) != 1 || nodes[0].Type() != tc.want {
+			t.Errorf("%d: nodes = %+v; want one node of type %v", i, nodes, tc.want)
+		}
+		if ps.t.Type != html.EndTagToken || ps.t.DataAtom != atom.P {
+			t.Errorf("%d: tokenizer left at %v; want </p>", i, ps.t)
+		}
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	const in = `id: table
+
+# Title
+
+## Step
+
+| Name | Value |
+|------|-------|
+| ` + "`flag`" + ` | [docs](https://example.com) **required** |
+`
+	clab, err := (&Parser{}).Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grid *types.GridNode
+	for _, n := range clab.Steps[0].Content.Nodes {
+		if g, ok := n.(*types.GridNode); ok {
+			grid = g
+		}
+	}
+	if grid == nil {
+		t.Fatal("no grid node found")
+	}
+	if len(grid.Rows) != 2 {
+		t.Fatalf("len(grid.Rows) = %d; want 2", len(grid.Rows))
+	}
+	for i, r := range grid.Rows {
+		if len(r) != 2 {
+			t.Fatalf("len(grid.Rows[%d]) = %d; want 2", i, len(r))
+		}
+	}
+	if h := grid.Rows[0][0].Content.Nodes[0].(*types.TextNode); !h.Bold || h.Value != "Name" {
+		t.Errorf("header cell = %+v; want bold Name", h)
+	}
+	if c := grid.Rows[1][0].Content.Nodes[0].(*types.TextNode); !c.Code || c.Value != "flag" {
+		t.Errorf("code cell = %+v; want code flag", c)
+	}
+	var got []types.NodeType
+	for _, n := range grid.Rows[1][1].Content.Nodes {
+		got = append(got, n.Type())
+	}
+	want := []types.NodeType{types.NodeURL, types.NodeText, types.NodeText}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cell node types = %v; want %v", got, want)
+	}
+}
+
+func TestParseNestedList(t *testing.T) {
+	const in = `* one **two** [link](https://example.com) ` + "`code`" + `
+* three
+    1. nested
+    2. list
+`
+	nodes, err := (&Parser{}).ParseFragment(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, ok := nodes[0].(*types.ItemsListNode)
+	if !ok || len(list.Items) != 2 {
+		t.Fatalf("nodes[0] = %+v; want items list with 2 items", nodes[0])
+	}
+
+	var got []types.NodeType
+	for _, n := range list.Items[0].Nodes {
+		if !n.Empty() {
+			got = append(got, n.Type())
+		}
+	}
+	want := []types.NodeType{types.NodeText, types.NodeText, types.NodeURL, types.NodeText}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("first item node types = %v; want %v", got, want)
+	}
+
+	var nested *types.ItemsListNode
+	for _, n := range list.Items[1].Nodes {
+		if l, ok := n.(*types.ItemsListNode); ok {
+			nested = l
+		}
+	}
+	if nested == nil || len(nested.Items) != 2 || nested.Start != 1 {
+		t.Errorf("nested list = %+v; want numbered list with 2 items", nested)
+	}
+}
+
+func TestParseInfobox(t *testing.T) {
+	const in = `Positive
+: A [link](https://example.com) and ` + "`code`" + `.
+
+    Second paragraph.
+
+Negative
+: Warning.
+`
+	nodes, err := (&Parser{}).ParseFragment(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var boxes []*types.InfoboxNode
+	for _, n := range nodes {
+		if ib, ok := n.(*types.InfoboxNode); ok {
+			boxes = append(boxes, ib)
+		}
+	}
+	if len(boxes) != 2 {
+		t.Fatalf("len(boxes) = %d; want 2", len(boxes))
+	}
+	if boxes[0].Kind != types.InfoboxPositive || boxes[1].Kind != types.InfoboxNegative {
+		t.Errorf("kinds = %q, %q; want %q, %q", boxes[0].Kind, boxes[1].Kind, types.InfoboxPositive, types.InfoboxNegative)
+	}
+	var paras []*types.ListNode
+	for _, n := range boxes[0].Content.Nodes {
+		if l, ok := n.(*types.ListNode); ok && l.Block() == true {
+			paras = append(paras, l)
+		}
+	}
+	if len(paras) != 2 {
+		t.Fatalf("len(paras) = %d; want 2", len(paras))
+	}
+	var hasURL bool
+	for _, n := range paras[0].Nodes {
+		hasURL = hasURL || n.Type() == types.NodeURL
+	}
+	if !hasURL {
+		t.Errorf("first paragraph %+v has no link", paras[0].Nodes)
+	}
+}
+
+func TestParseInfoboxImport(t *testing.T) {
+	const in = `Positive
+: [[import note.md]]
+
+    Second paragraph.
+
+Text after the box.
+`
+	nodes, err := (&Parser{}).ParseFragment(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) == 0 {
+		t.Fatal("no nodes")
+	}
+	ib, ok := nodes[0].(*types.InfoboxNode)
+	if !ok {
+		t.Fatalf("nodes[0] = %+v; want infobox", nodes[0])
+	}
+	var kinds []types.NodeType
+	for _, n := range ib.Content.Nodes {
+		if l, ok := n.(*types.ListNode); ok {
+			for _, n := range l.Nodes {
+				kinds = append(kinds, n.Type())
+			}
+		}
+	}
+	if want := []types.NodeType{types.NodeImport, types.NodeText}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("infobox paragraph content = %v; want %v", kinds, want)
+	}
+	if !strings.Contains(stringifyNodes(nodes[1:]), "Text after the box.") {
+		t.Errorf("content after the infobox = %+v", nodes[1:])
+	}
+}
+
+func TestParseSpecialHeaders(t *testing.T) {
+	const in = `### What you'll learn
+
+* [one](https://example.com)
+* two
+
+### Frequently Asked **Questions**
+
+* three
+
+### Regular ` + "`header`" + `
+
+* four
+`
+	nodes, err := (&Parser{}).ParseFragment(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []types.NodeType
+	for _, n := range nodes {
+		if !n.Empty() {
+			got = append(got, n.Type())
+		}
+	}
+	want := []types.NodeType{
+		types.NodeHeaderCheck, types.NodeItemsCheck,
+		types.NodeHeaderFAQ, types.NodeItemsFAQ,
+		types.NodeHeader, types.NodeItemsList,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("node types = %v; want %v", got, want)
+	}
+	for _, n := range nodes {
+		if h, ok := n.(*types.HeaderNode); ok && h.Type() == types.NodeHeaderFAQ && len(h.Content.Nodes) != 2 {
+			t.Errorf("len(FAQ header content) = %d; want 2", len(h.Content.Nodes))
+		}
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	const in = `id: lab
+
+# Title
+
+## Step
+
+[[youtube https://www.youtube.com/watch?v=abc-123]]
+
+### Web only
+
+[[environment web]]
+
+Web text.
+
+### Everyone
+
+[[survey]]
+
+#### How will you use this tutorial?
+
+* Read it through only
+* Read it and complete the exercises
+
+#### How would you rate your experience?
+
+* Novice
+* Proficient
+
+Text after the survey.
+`
+	clab, err := (&Parser{}).Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := clab.Steps[0]
+	if !reflect.DeepEqual(step.Tags, []string{"web"}) {
+		t.Errorf("step.Tags = %v; want [web]", step.Tags)
+	}
+	if !reflect.DeepEqual(clab.Tags, []string{"web"}) {
+		t.Errorf("clab.Tags = %v; want [web]", clab.Tags)
+	}
+
+	var yt *types.YouTubeNode
+	var sn *types.SurveyNode
+	var headers []*types.HeaderNode
+	var texts []*types.TextNode
+	for _, n := range step.Content.Nodes {
+		switch n := n.(type) {
+		case *types.YouTubeNode:
+			yt = n
+		case *types.SurveyNode:
+			sn = n
+		case *types.HeaderNode:
+			headers = append(headers, n)
+		case *types.TextNode:
+			if strings.TrimSpace(n.Value) != "" {
+				texts = append(texts, n)
+			}
+		}
+	}
+	if yt == nil || yt.VideoID != "abc-123" {
+		t.Errorf("youtube node = %+v; want video abc-123", yt)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("len(headers) = %d; want 2", len(headers))
+	}
+	if !reflect.DeepEqual(headers[0].Env(), []string{"web"}) || len(headers[1].Env()) != 0 {
+		t.Errorf("header envs = %v, %v; want [web], []", headers[0].Env(), headers[1].Env())
+	}
+	if len(texts) != 2 || !reflect.DeepEqual(texts[0].Env(), []string{"web"}) || len(texts[1].Env()) != 0 {
+		t.Errorf("paragraphs = %+v; want 2 paragraphs, the first one in web env", texts)
+	}
+
+	want := types.NewSurveyNode("lab-1",
+		&types.SurveyGroup{
+			Name:    "How will you use this tutorial?",
+			Options: []string{"Read it through only", "Read it and complete the exercises"},
+		},
+		&types.SurveyGroup{
+			Name:    "How would you rate your experience?",
+			Options: []string{"Novice", "Proficient"},
+		},
+	)
+	if sn == nil || sn.ID != want.ID || !reflect.DeepEqual(sn.Groups, want.Groups) {
+		t.Errorf("survey = %+v; want %+v", sn, want)
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		in   string
+		fm   string
+		rest string
+		ok   bool
+	}{
+		{"---\nid: a\n---\n# Title\n", "id: a\n", "# Title\n", true},
+		{"---\r\nid: a\r\n---\r\n# Title\r\n", "id: a\r\n", "# Title\r\n", true},
+		{"---\n---\n", "", "", true},
+		{"id: a\n\n# Title\n", "", "id: a\n\n# Title\n", true},
+		{"---\nid: a\n# Title\n", "", "", false},
+	}
+	for i, tc := range tests {
+		fm, rest, err := splitFrontMatter([]byte(tc.in))
+		if (err == nil) != tc.ok {
+			t.Errorf("%d: splitFrontMatter(%q) err = %v; want ok = %v", i, tc.in, err, tc.ok)
+			continue
+		}
+		if string(fm) != tc.fm || string(rest) != tc.rest {
+			t.Errorf("%d: splitFrontMatter(%q) = %q, %q; want %q, %q", i, tc.in, fm, rest, tc.fm, tc.rest)
+		}
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	const in = `---
+id: front-matter
+summary: "Summary: with a colon"
+author: john smith
+duration: 45
+categories: [Web, Android]
+tags:
+  - web
+  - Kiosk
+status: draft
+feedback: https://example.com/issues
+ga: UA-12345
+---
+
+# Title
+
+## Step
+Duration: 0:10
+
+Text.
+`
+	clab, err := (&Parser{}).Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := types.LegacyStatus{"draft"}
+	want := types.Meta{
+		ID:         "front-matter",
+		Title:      "Title",
+		Summary:    "Summary: with a colon",
+		Author:     "john smith",
+		Duration:   45,
+		Categories: []string{"web", "android"},
+		Tags:       []string{"kiosk", "web"},
+		Status:     &status,
+		Feedback:   "https://example.com/issues",
+		GA:         "UA-12345",
+	}
+	if !reflect.DeepEqual(clab.Meta, want) {
+		t.Errorf("clab.Meta = %+v; want %+v", clab.Meta, want)
+	}
+
+	bad := []string{
+		"---\nid: a\nunknown: b\n---\n# Title\n",
+		"---\nduration: an hour\n---\n# Title\n",
+		"---\ntags: {web: true}\n---\n# Title\n",
+		"---\nid: a\n# Title\n",
+		"---\nga: UA-1\nanalytics account: UA-2\n---\n# Title\n",
+	}
+	for i, in := range bad {
+		if _, err := (&Parser{}).Parse(strings.NewReader(in)); err == nil {
+			t.Errorf("%d: Parse(%q) succeeded; want error", i, in)
+		}
+	}
+
+	// Keys of the metadata lines
+	clab, err = (&Parser{}).Parse(strings.NewReader("---\nfeedback link: https://example.com/issues\nanalytics account: UA-12345\n---\n# Title\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clab.Feedback != "https://example.com/issues" || clab.GA != "UA-12345" {
+		t.Errorf("clab.Feedback, clab.GA = %q, %q; want https://example.com/issues, UA-12345", clab.Feedback, clab.GA)
+	}
+}
+
+func TestHandleFencedCodeBlock(t *testing.T) {
+	tests := []struct {
+		in   string
+		term bool
+		lang string
+	}{
+		{"```go\nfunc main() {}\n```\n", false, "go"},
+		{"```\nplain\n```\n", false, ""},
+		{"```console\n$ claat export doc\n```\n", true, ""},
+		{"```shell-session\n$ ls\n```\n", true, ""},
+		{"```Terminal\n$ ls\n```\n", true, ""},
+	}
+	for i, tc := range tests {
+		nodes, err := (&Parser{}).ParseFragment(strings.NewReader(tc.in))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		var code *types.CodeNode
+		for _, n := range nodes {
+			if c, ok := n.(*types.CodeNode); ok {
+				code = c
+			}
+		}
+		if code == nil {
+			t.Errorf("%d: no code node in %+v", i, nodes)
+			continue
+		}
+		if code.Term != tc.term || code.Lang != tc.lang {
+			t.Errorf("%d: code = %+v; want Term = %v, Lang = %q", i, code, tc.term, tc.lang)
+		}
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	const in = `---
+id: lab
+---
+
+# Title
+
+## Step
+
+First paragraph.
+
+### Header
+
+* One
+* Two
+
+![image](img.png)
+
+` + "```go\nfunc main() {}\n```" + `
+
+Last "paragraph".
+`
+	clab, err := (&Parser{}).Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []types.Pos
+	for _, n := range clab.Steps[0].Content.Nodes {
+		if t, ok := n.(*types.TextNode); ok && strings.TrimSpace(t.Value) == "" {
+			// whitespace between blocks
+			continue
+		}
+		got = append(got, n.Pos())
+	}
+	want := []types.Pos{
+		{Line: 9, Column: 1},
+		{Line: 11, Column: 5},
+		{Line: 13, Column: 3},
+		{Line: 16, Column: 10},
+		{Line: 19, Column: 1},
+		{Line: 22, Column: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("positions = %v; want %v", got, want)
+	}
+}
+
+func TestParseRepeatedTextPositions(t *testing.T) {
+	// Text of the second paragraph first appears in the link target,
+	// which is not part of any text token.
+	const in = "[link](https://example.com/setup)\n\nsetup\n\n### setup\n"
+	nodes, err := (&Parser{}).ParseFragment(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []types.Pos
+	for _, n := range nodes {
+		if t, ok := n.(*types.TextNode); ok && strings.TrimSpace(t.Value) == "" {
+			continue
+		}
+		got = append(got, n.Pos())
+	}
+	want := []types.Pos{
+		{Line: 1, Column: 2},
+		{Line: 3, Column: 1},
+		{Line: 5, Column: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("positions = %v; want %v", got, want)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	tests := []struct{ in, err string }{
+		{"id: lab\n\nno metadata\n\n# Title\n", `3:1: invalid metadata format: "no metadata"`},
+		{"id: lab\n\n# Title\n\n## Step\n\nDuration: 1:xx\n", `7:1: step "Step": unrecognized duration string`},
+		{"summary: lab\n\nid: ../../etc\n\n# Title\n", `3:1: invalid codelab ID "../../etc": must start with a letter or digit, followed by letters, digits, '.', '_' or '-'`},
+		{"---\nid: lab\nflavor: vanilla\n---\n\n# Title\n", `3: invalid front matter: field flavor not found in type md.frontMatter`},
+		{"---\nid: lab\nduration: -1\n---\n\n# Title\n", `3: invalid front matter: negative duration -1`},
+		{"---\nid: lab\n\n# Title\n", `1: front matter is missing the closing ---`},
+		{"---\nid: a/b\n---\n\n# Title\n", `2: invalid front matter: invalid codelab ID "a/b": must start with a letter or digit, followed by letters, digits, '.', '_' or '-'`},
+	}
+	for i, test := range tests {
+		_, err := (&Parser{}).Parse(strings.NewReader(test.in))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%d: err = %v; want %s", i, err, test.err)
+		}
+	}
+}
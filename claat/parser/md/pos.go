@@ -0,0 +1,191 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package md
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+// blockMarker starts HTML comments inserted into the Markdown source before top-level blocks, see markup.
+// It is followed by the block number.
+const blockMarker = "claat:"
+
+// listItemRegexp matches lines starting a list item.
+var listItemRegexp = regexp.MustCompile(`^([*+-]|\d+[.)])(\s|$)`)
+
+// source is the Markdown source of the markup being parsed.
+// Since the markup itself has no notion of the original lines, the source is marked up with the offsets
+// of its top-level blocks, see markup. The tokenizer reports each block it reaches with seek, and text found
+// in the markup is then located by searching forward from the block start, up to the next block.
+type source struct {
+	b      []byte // Markdown source
+	blocks []int  // offsets of the marked blocks
+	lines  []int  // line numbers of the marked blocks
+	off    int    // offset right after the last located text
+	end    int    // offset of the block following the current one
+	line   int    // line number at off
+}
+
+// newSource creates a new source of b. The first line of b is line number line.
+func newSource(b []byte, line int) *source {
+	src := &source{b: b, end: len(b), line: line}
+	prevBlank, fenced := true, false
+	for i, n := 0, line; i < len(b); n++ {
+		l, next := nextLine(b, i)
+		t := strings.TrimSpace(string(l))
+		// A block may be marked if it doesn't continue the previous one.
+		if !fenced && prevBlank && t != "" && l[0] != ' ' && l[0] != '\t' && l[0] != ':' && !listItemRegexp.MatchString(t) {
+			src.blocks = append(src.blocks, i)
+			src.lines = append(src.lines, n)
+		}
+		if strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~") {
+			fenced = !fenced
+		}
+		prevBlank = t == ""
+		i = next
+	}
+	return src
+}
+
+// markup returns the Markdown source with an HTML comment before each of its blocks.
+// The comments tell the parser which block of the source the following markup comes from,
+// and are dropped by the parser along with the blank line following them.
+func (src *source) markup() []byte {
+	var buf bytes.Buffer
+	var off int
+	for i, b := range src.blocks {
+		buf.Write(src.b[off:b])
+		fmt.Fprintf(&buf, "<!-- %s%d -->\n\n", blockMarker, i)
+		off = b
+	}
+	buf.Write(src.b[off:])
+	return buf.Bytes()
+}
+
+// seek moves to the start of block i, as reported by the marker comment s.
+// It returns the position of the block, or invalid position if s is not a marker, or if src is nil.
+func (src *source) seek(s string) types.Pos {
+	s = strings.TrimSpace(s)
+	if src == nil || !strings.HasPrefix(s, blockMarker) {
+		return types.Pos{}
+	}
+	i, err := strconv.Atoi(s[len(blockMarker):])
+	if err != nil || i < 0 || i >= len(src.blocks) {
+		return types.Pos{}
+	}
+	src.off, src.line = src.blocks[i], src.lines[i]
+	src.end = len(src.b)
+	if i+1 < len(src.blocks) {
+		src.end = src.blocks[i+1]
+	}
+	return types.Pos{Line: src.line, Column: 1}
+}
+
+// isMarker reports whether comment s is a block marker.
+func isMarker(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), blockMarker)
+}
+
+// locate returns the position of text s, as found in the markup, in the Markdown source.
+// It returns invalid position if s cannot be found in the current block, or if src is nil.
+func (src *source) locate(s string) types.Pos {
+	if src == nil {
+		return types.Pos{}
+	}
+	pre, k := locateKey(s)
+	if k == "" {
+		return types.Pos{}
+	}
+	i := bytes.Index(src.b[src.off:src.end], []byte(k))
+	if i < 0 {
+		return types.Pos{}
+	}
+	i += src.off
+	src.line += bytes.Count(src.b[src.off:i], []byte("\n"))
+	src.off = i + len(k)
+	// Point at the start of s if its prefix is verbatim in the source too.
+	if i-len(pre) >= 0 && string(src.b[i-len(pre):i]) == pre {
+		i -= len(pre)
+	}
+	return types.Pos{
+		Line:   src.line,
+		Column: i - bytes.LastIndexByte(src.b[:i], '\n'),
+	}
+}
+
+// locateKey returns a key of s which is likely to appear verbatim in the Markdown source,
+// that is the first run of plain characters up to a character the Markdown processor may have produced
+// from something else, such as smart quotes, dashes or HTML entities, or the end of the first line.
+// Non-plain characters preceding the key are returned in pre.
+func locateKey(s string) (pre, key string) {
+	s = strings.TrimSpace(s)
+	start := -1
+	for i, r := range s {
+		plain := unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" ,:;!?()/=+@%", r)
+		if start < 0 && plain && r != ' ' {
+			start = i
+		}
+		if start >= 0 && !plain {
+			return s[:start], strings.TrimSpace(s[start:i])
+		}
+	}
+	if start < 0 {
+		return "", ""
+	}
+	return s[:start], strings.TrimSpace(s[start:])
+}
+
+// firstPos returns the position of the first node in nodes, including their children, which has a valid position.
+func firstPos(nodes ...types.Node) types.Pos {
+	for _, n := range nodes {
+		if n.Pos().IsValid() {
+			return n.Pos()
+		}
+		var children []types.Node
+		switch n := n.(type) {
+		case *types.ListNode:
+			children = n.Nodes
+		case *types.HeaderNode:
+			children = n.Content.Nodes
+		case *types.URLNode:
+			children = n.Content.Nodes
+		case *types.ButtonNode:
+			children = n.Content.Nodes
+		case *types.InfoboxNode:
+			children = n.Content.Nodes
+		case *types.ItemsListNode:
+			for _, it := range n.Items {
+				children = append(children, it)
+			}
+		case *types.GridNode:
+			for _, r := range n.Rows {
+				for _, c := range r {
+					children = append(children, c.Content)
+				}
+			}
+		}
+		if p := firstPos(children...); p.IsValid() {
+			return p
+		}
+	}
+	return types.Pos{}
+}
@@ -76,6 +76,20 @@ func Parse(name string, r io.Reader) (*types.Codelab, error) {
 	return c, err
 }
 
+// PosError is a parser error which occurred at a specific source position.
+type PosError struct {
+	Pos types.Pos
+	Err error
+}
+
+// Error implements error interface.
+func (e *PosError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
 // ParseFragment parses a codelab fragment provided in r, using a parser
 // registered with the specified name.
 func ParseFragment(name string, r io.Reader) ([]types.Node, error) {
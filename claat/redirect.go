@@ -0,0 +1,193 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// redirectsFilename is the redirect map of codelabs whose ID has changed,
+// relative to the base dir of codelabs.
+const redirectsFilename = "redirects.json"
+
+// redirectMap is a set of redirects from a codelab dir which has moved,
+// to be recorded in the redirect map of its base dir with addRedirects.
+//
+// The redirect map is a JSON object of old paths to new paths, relative
+// to the base dir and slash-separated. Directories are included, ending with a slash.
+type redirectMap struct {
+	file   string            // redirect map file in the base dir
+	newdir string            // slash-terminated path of the new codelab dir
+	paths  map[string]string // old path => new path
+}
+
+// writeRedirects replaces codelab dir old with redirects to newdir,
+// both in basedir, and returns the redirects to record with addRedirects.
+// Callers updating codelabs concurrently must not record them concurrently.
+//
+// Each HTML page of old, such as index.html and step-N.html of the offline
+// format, is replaced with a stub redirecting to the same page in newdir,
+// or newdir/index.html if the page no longer exists. All other files,
+// including codelab metadata, are removed.
+//
+// If old has no HTML pages, as with the md format, there is nothing
+// to redirect from: old is removed and the returned redirects are empty.
+func writeRedirects(basedir, old, newdir string) (*redirectMap, error) {
+	to, err := filepath.Rel(basedir, newdir)
+	if err != nil {
+		return nil, err
+	}
+	r := &redirectMap{
+		file:   filepath.Join(basedir, redirectsFilename),
+		newdir: filepath.ToSlash(to) + "/",
+		paths:  make(map[string]string),
+	}
+	pages, err := redirectPages(old)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return r, os.RemoveAll(old)
+	}
+	err = replaceStaged(old, func(staged string) error {
+		for _, rel := range pages {
+			p := filepath.Join(old, rel)
+			target := filepath.Join(newdir, rel)
+			if _, err := os.Stat(target); err != nil {
+				target = filepath.Join(newdir, "index.html")
+			}
+			href, err := filepath.Rel(filepath.Dir(p), target)
+			if err != nil {
+				return err
+			}
+			stub := filepath.Join(staged, rel)
+			if err := os.MkdirAll(filepath.Dir(stub), 0755); err != nil {
+				return err
+			}
+			if err := writeRedirectStub(stub, filepath.ToSlash(href)); err != nil {
+				return err
+			}
+			from, err := filepath.Rel(basedir, p)
+			if err != nil {
+				return err
+			}
+			to, err := filepath.Rel(basedir, target)
+			if err != nil {
+				return err
+			}
+			r.paths[filepath.ToSlash(from)] = filepath.ToSlash(to)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	from, err := filepath.Rel(basedir, old)
+	if err != nil {
+		return nil, err
+	}
+	r.paths[filepath.ToSlash(from)+"/"] = r.newdir
+	return r, nil
+}
+
+// redirectPages returns paths of HTML pages in dir, relative to dir,
+// which writeRedirects replaces with redirect stubs.
+func redirectPages(dir string) ([]string, error) {
+	var pages []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || filepath.Ext(p) != ".html" {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, rel)
+		return nil
+	})
+	return pages, err
+}
+
+// writeRedirectStub writes an HTML page to file,
+// which redirects to href.
+func writeRedirectStub(file, href string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := redirectTemplate.Execute(f, href); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// redirectTemplate is a redirect stub page, executed with the target URL.
+var redirectTemplate = template.Must(template.New("redirect").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Moved</title>
+<link rel="canonical" href="{{.}}">
+<meta http-equiv="refresh" content="0; url={{.}}">
+</head>
+<body>
+<p>This codelab has moved to <a href="{{.}}">{{.}}</a>.</p>
+</body>
+</html>
+`))
+
+// addRedirects merges redirects r into the redirect map stored in r.file.
+//
+// Existing redirects pointing to one of the r.paths keys are updated
+// to their new target, so that there are no redirect chains.
+// Redirects from r.newdir, which now exists, are removed.
+func addRedirects(r *redirectMap) error {
+	m := make(map[string]string)
+	b, err := ioutil.ReadFile(r.file)
+	if os.IsNotExist(err) && len(r.paths) == 0 {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+	}
+	for from := range m {
+		if strings.HasPrefix(from, r.newdir) {
+			delete(m, from)
+		}
+	}
+	for from, to := range m {
+		if t, ok := r.paths[to]; ok {
+			m[from] = t
+		}
+	}
+	for from, to := range r.paths {
+		m[from] = to
+	}
+	if b, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return err
+	}
+	return writeFileAtomic(r.file, append(b, '\n'))
+}
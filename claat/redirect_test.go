@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteRedirects(t *testing.T) {
+	base, err := ioutil.TempDir("", "claat-redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	files := []string{
+		"lab/index.html",
+		"lab/step-2.html",
+		"lab/step-3.html",
+		"lab/img/a.png",
+		"lab/" + metaFilename,
+		"lab2/index.html",
+		"lab2/step-2.html",
+	}
+	for _, name := range files {
+		p := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rfile := filepath.Join(base, redirectsFilename)
+	existing := `{"old/": "lab/", "old/index.html": "lab/index.html", "lab2/": "gone/"}`
+	if err := ioutil.WriteFile(rfile, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	old, newdir := filepath.Join(base, "lab"), filepath.Join(base, "lab2")
+	r, err := writeRedirects(base, old, newdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addRedirects(r); err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if want := []string{"index.html", "step-2.html", "step-3.html"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("old dir contains %q; want %q", names, want)
+	}
+	stubs := map[string]string{
+		"step-2.html": "../lab2/step-2.html",
+		"step-3.html": "../lab2/index.html",
+	}
+	for name, href := range stubs {
+		b, err := ioutil.ReadFile(filepath.Join(old, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			`<link rel="canonical" href="` + href + `">`,
+			`<meta http-equiv="refresh" content="0; url=` + href + `">`,
+		} {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%s does not contain %s:\n%s", name, want, b)
+			}
+		}
+	}
+
+	b, err := ioutil.ReadFile(rfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"old/":            "lab2/",
+		"old/index.html":  "lab2/index.html",
+		"lab/":            "lab2/",
+		"lab/index.html":  "lab2/index.html",
+		"lab/step-2.html": "lab2/step-2.html",
+		"lab/step-3.html": "lab2/index.html",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("redirects = %v; want %v", m, want)
+	}
+}
+
+func TestWriteRedirectsNoPages(t *testing.T) {
+	base, err := ioutil.TempDir("", "claat-redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	for _, name := range []string{"lab/index.md", "lab2/index.md"} {
+		p := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old, newdir := filepath.Join(base, "lab"), filepath.Join(base, "lab2")
+	r, err := writeRedirects(base, old, newdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.paths) != 0 {
+		t.Errorf("redirects = %v; want none", r.paths)
+	}
+	if err := addRedirects(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old dir: %v; want it removed", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, redirectsFilename)); !os.IsNotExist(err) {
+		t.Errorf("%s: %v; want no redirect map", redirectsFilename, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Env is a parsed environment expression, which nodes and steps
+// are matched against by their environment tags.
+//
+// An expression is either a single environment, such as "web",
+// or a boolean combination of environments using "and", "or", "not"
+// and parentheses, e.g. "web and not kiosk". The operators can also
+// be written as "&&", "||" and "!". In order of precedence,
+// from highest to lowest, they are: not, and, or.
+//
+// An environment in an expression is true if it is one of the tags
+// being matched. Tags which are not in the expression are irrelevant.
+type Env struct {
+	expr string
+	eval func(tags []string) bool // nil for an empty expression
+}
+
+// ParseEnv parses environment expression expr.
+// An empty expr matches all tags.
+func ParseEnv(expr string) (*Env, error) {
+	e := &Env{expr: expr}
+	p := &envParser{tokens: envTokens(expr)}
+	if len(p.tokens) == 0 {
+		return e, nil
+	}
+	eval, err := p.or()
+	if err == nil && p.i < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.i])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid environment expression %q: %v", expr, err)
+	}
+	e.eval = eval
+	return e, nil
+}
+
+// String returns the original expression e was parsed from.
+func (e *Env) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.expr
+}
+
+// Match reports whether tags satisfy e.
+// Empty tags, as well as a nil or empty e, always match.
+func (e *Env) Match(tags []string) bool {
+	if len(tags) == 0 || e == nil || e.eval == nil {
+		return true
+	}
+	return e.eval(tags)
+}
+
+// MatchEnv reports whether tags satisfy environment expression expr.
+// It is available to templates as "matchEnv".
+func MatchEnv(tags []string, expr string) (bool, error) {
+	e, err := ParseEnv(expr)
+	if err != nil {
+		return false, err
+	}
+	return e.Match(tags), nil
+}
+
+// envTokens splits expression s into parentheses, operators and environments.
+func envTokens(s string) []string {
+	var tokens []string
+	start := -1 // start of current word
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, s[start:end])
+			start = -1
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '(' || c == ')' || c == '!':
+			flush(i)
+			tokens = append(tokens, s[i:i+1])
+		case (c == '&' || c == '|') && i+1 < len(s) && s[i+1] == c:
+			flush(i)
+			tokens = append(tokens, s[i:i+2])
+			i++
+		case unicode.IsSpace(rune(c)):
+			flush(i)
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	flush(len(s))
+	return tokens
+}
+
+// envParser is a recursive descent parser of environment expressions.
+type envParser struct {
+	tokens []string
+	i      int // current token index
+}
+
+// accept consumes the current token if it is one of the ops,
+// compared case-insensitively.
+func (p *envParser) accept(ops ...string) bool {
+	if p.i >= len(p.tokens) {
+		return false
+	}
+	for _, op := range ops {
+		if strings.EqualFold(p.tokens[p.i], op) {
+			p.i++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *envParser) or() (func([]string) bool, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or", "||") {
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = func(a, b func([]string) bool) func([]string) bool {
+			return func(tags []string) bool { return a(tags) || b(tags) }
+		}(x, y)
+	}
+	return x, nil
+}
+
+func (p *envParser) and() (func([]string) bool, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and", "&&") {
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = func(a, b func([]string) bool) func([]string) bool {
+			return func(tags []string) bool { return a(tags) && b(tags) }
+		}(x, y)
+	}
+	return x, nil
+}
+
+func (p *envParser) unary() (func([]string) bool, error) {
+	if p.i >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	if p.accept("not", "!") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(tags []string) bool { return !x(tags) }, nil
+	}
+	if p.accept("(") {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return x, nil
+	}
+	t := p.tokens[p.i]
+	switch strings.ToLower(t) {
+	case ")", "and", "&&", "or", "||":
+		return nil, fmt.Errorf("unexpected %q", t)
+	}
+	p.i++
+	return func(tags []string) bool {
+		for _, tag := range tags {
+			if tag == t {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		tags []string
+		ok   bool
+	}{
+		{"", []string{"web"}, true},
+		{"web", nil, true},
+		{"web", []string{"kiosk", "web"}, true},
+		{"web", []string{"kiosk"}, false},
+		{"web and beginner", []string{"beginner", "web"}, true},
+		{"web and beginner", []string{"web"}, false},
+		{"web or kiosk", []string{"kiosk"}, true},
+		{"not kiosk", []string{"web"}, true},
+		{"not kiosk", []string{"kiosk", "web"}, false},
+		{"!kiosk && (web || io)", []string{"io"}, true},
+		{"web or kiosk and beginner", []string{"web"}, true},
+		{"(web or kiosk) and beginner", []string{"web"}, false},
+		{"NOT not web", []string{"web"}, true},
+	}
+	for i, test := range tests {
+		e, err := ParseEnv(test.expr)
+		if err != nil {
+			t.Errorf("%d: ParseEnv(%q): %v", i, test.expr, err)
+			continue
+		}
+		if ok := e.Match(test.tags); ok != test.ok {
+			t.Errorf("%d: ParseEnv(%q).Match(%q) = %v; want %v", i, test.expr, test.tags, ok, test.ok)
+		}
+	}
+}
+
+func TestParseEnvError(t *testing.T) {
+	tests := []struct{ expr, err string }{
+		{"web and", "unexpected end"},
+		{"(web", "missing )"},
+		{"web kiosk", `unexpected "kiosk"`},
+		{"or web", `unexpected "or"`},
+		{"web)", `unexpected ")"`},
+	}
+	for i, test := range tests {
+		_, err := ParseEnv(test.expr)
+		if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+			t.Errorf("%d: ParseEnv(%q): %v; want %s", i, test.expr, err, test.err)
+		}
+	}
+}
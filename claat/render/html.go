@@ -19,7 +19,6 @@ import (
 	"fmt"
 	htmlTemplate "html/template"
 	"io"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -46,27 +45,23 @@ func HTML(env string, nodes ...types.Node) (htmlTemplate.HTML, error) {
 
 // WriteHTML does the same as HTML but outputs rendered markup to w.
 func WriteHTML(w io.Writer, env string, nodes ...types.Node) error {
-	hw := htmlWriter{w: w, env: env}
+	e, err := ParseEnv(env)
+	if err != nil {
+		return err
+	}
+	hw := htmlWriter{w: w, env: e}
 	return hw.write(nodes...)
 }
 
 type htmlWriter struct {
 	w   io.Writer // output writer
-	env string    // target environment
+	env *Env      // target environment
 	err error     // error during any writeXxx methods
 }
 
-func (hw *htmlWriter) matchEnv(v []string) bool {
-	if len(v) == 0 || hw.env == "" {
-		return true
-	}
-	i := sort.SearchStrings(v, hw.env)
-	return i < len(v) && v[i] == hw.env
-}
-
 func (hw *htmlWriter) write(nodes ...types.Node) error {
 	for _, n := range nodes {
-		if !hw.matchEnv(n.Env()) {
+		if !hw.env.Match(n.Env()) {
 			continue
 		}
 		switch n := n.(type) {
@@ -37,6 +37,8 @@ func TestHTMLEnv(t *testing.T) {
 		{"two", "two "},
 		{"three", "three "},
 		{"four", ""},
+		{"one and three", "three "},
+		{"not one", "two "},
 	}
 	for i, test := range tests {
 		h, err := HTML(test.env, one, two, three)
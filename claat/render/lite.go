@@ -19,7 +19,6 @@ import (
 	"fmt"
 	htmlTemplate "html/template"
 	"io"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -40,24 +39,20 @@ func Lite(env string, nodes ...types.Node) (htmlTemplate.HTML, error) {
 
 // WriteLite does the same as Lite but outputs rendered markup to w.
 func WriteLite(w io.Writer, env string, nodes ...types.Node) error {
-	lw := liteWriter{w: w, env: env}
+	e, err := ParseEnv(env)
+	if err != nil {
+		return err
+	}
+	lw := liteWriter{w: w, env: e}
 	return lw.write(nodes...)
 }
 
 type liteWriter struct {
 	w   io.Writer // output writer
-	env string    // target environment
+	env *Env      // target environment
 	err error     // error during any writeXxx methods
 }
 
-func (lw *liteWriter) matchEnv(v []string) bool {
-	if len(v) == 0 || lw.env == "" {
-		return true
-	}
-	i := sort.SearchStrings(v, lw.env)
-	return i < len(v) && v[i] == lw.env
-}
-
 func (lw *liteWriter) write(nodes ...types.Node) error {
 	doc := &html.Node{Type: html.DocumentNode}
 	for _, n := range nodes {
@@ -69,7 +64,7 @@ func (lw *liteWriter) write(nodes ...types.Node) error {
 }
 
 func (lw *liteWriter) htmlnode(n types.Node) *html.Node {
-	if !lw.matchEnv(n.Env()) {
+	if !lw.env.Match(n.Env()) {
 		return nil
 	}
 	var hn *html.Node
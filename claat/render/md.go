@@ -16,15 +16,34 @@ package render
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"path"
-	"sort"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/googlecodelabs/tools/claat/types"
 )
 
+// mdEscaper escapes characters with a special meaning anywhere in Markdown text.
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+)
+
+// mdLineStartRegexp matches text which has a special meaning at the start of a line,
+// such as headers, quotes and unordered list items.
+var mdLineStartRegexp = regexp.MustCompile(`^(?:[#>]|[-+=](?:\s|$))`)
+
+// mdOrderedRegexp matches text which would start an ordered list item at the start of a line.
+var mdOrderedRegexp = regexp.MustCompile(`^(\d+)\.(\s|$)`)
+
 // MD renders nodes as markdown for the target env.
 func MD(env string, nodes ...types.Node) (string, error) {
 	var buf bytes.Buffer
@@ -36,22 +55,98 @@ func MD(env string, nodes ...types.Node) (string, error) {
 
 // WriteMD does the same as MD but outputs rendered markup to w.
 func WriteMD(w io.Writer, env string, nodes ...types.Node) error {
-	mw := mdWriter{w: w, env: env}
+	e, err := ParseEnv(env)
+	if err != nil {
+		return err
+	}
+	mw := mdWriter{w: w, env: e, lineStart: true}
 	return mw.write(nodes...)
 }
 
+// WriteMDSource writes codelab c to w as a Markdown source of the md parser,
+// which parses it back into an equivalent codelab.
+// Unlike WriteMD, it includes nodes of all environments, along with
+// metadata, step durations and directives, such as [[survey]].
+//
+// Headers are written at their Level, except that second level
+// headers are written as third level ones, since steps are second
+// level headers in Markdown.
+func WriteMDSource(w io.Writer, c *types.Codelab) error {
+	mw := mdWriter{w: w, lineStart: true, source: true}
+	var status string
+	if c.Status != nil {
+		status = strings.Join(*c.Status, ", ")
+	}
+	meta := []struct{ key, val string }{
+		{"id", c.ID},
+		{"summary", c.Summary},
+		{"author", c.Author},
+		{"categories", strings.Join(c.Categories, ", ")},
+		{"environments", strings.Join(c.Tags, ", ")},
+		{"status", status},
+		{"feedback link", c.Feedback},
+		{"analytics account", c.GA},
+	}
+	for _, m := range meta {
+		if v := strings.Join(strings.Fields(m.val), " "); v != "" {
+			mw.writeString(m.key + ": " + v + "\n\n")
+		}
+	}
+	mw.writeString("# ")
+	mw.writeString(mw.escape(c.Title))
+	mw.writeBytes(newLine)
+
+	for _, st := range c.Steps {
+		mw.newBlock()
+		mw.writeString("## ")
+		mw.writeString(mw.escape(st.Title))
+		mw.writeBytes(newLine)
+		if st.Duration > 0 {
+			mw.newBlock()
+			mw.writeString(fmt.Sprintf("Duration: %d:%02d", int(st.Duration.Hours()), int(st.Duration.Minutes())%60))
+			mw.writeBytes(newLine)
+		}
+		// Node environments are set with [[environment]] directives, which apply
+		// to the following nodes up to the next header, and to a header directly
+		// preceding them.
+		var env []string
+		for _, n := range st.Content.Nodes {
+			isHeader := types.IsHeader(n.Type())
+			if isHeader {
+				env = nil
+			}
+			if !isHeader && !equalStrings(n.Env(), env) {
+				mw.directive("environment", strings.Join(n.Env(), ", "))
+				env = n.Env()
+			}
+			mw.write(n)
+			if isHeader && len(n.Env()) > 0 {
+				mw.directive("environment", strings.Join(n.Env(), ", "))
+				env = n.Env()
+			}
+			if n.Type() == types.NodeSurvey {
+				// survey questions are headers, which reset the environment
+				env = nil
+			}
+		}
+	}
+	return mw.err
+}
+
 type mdWriter struct {
 	w         io.Writer // output writer
-	env       string    // target environment
+	env       *Env      // target environment
 	err       error     // error during any writeXxx methods
 	lineStart bool
+	table     bool // writing a table cell
+	source    bool // writing a Markdown source for the md parser, see WriteMDSource
 }
 
 func (mw *mdWriter) writeBytes(b []byte) {
-	if mw.err != nil {
+	if mw.err != nil || len(b) == 0 {
 		return
 	}
-	mw.lineStart = len(b) > 0 && b[len(b)-1] == '\n'
+	mw.lineStart = b[len(b)-1] == '\n'
 	_, mw.err = mw.w.Write(b)
 }
 
@@ -72,17 +167,39 @@ func (mw *mdWriter) newBlock() {
 	mw.writeBytes(newLine)
 }
 
-func (mw *mdWriter) matchEnv(v []string) bool {
-	if len(v) == 0 || mw.env == "" {
-		return true
+// render writes nodes into a string, using a new writer with the same settings.
+// Leading and trailing new lines are trimmed.
+func (mw *mdWriter) render(nodes ...types.Node) string {
+	var buf bytes.Buffer
+	w := mdWriter{w: &buf, env: mw.env, lineStart: true, table: mw.table, source: mw.source}
+	if err := w.write(nodes...); err != nil && mw.err == nil {
+		mw.err = err
+	}
+	return strings.Trim(buf.String(), "\n")
+}
+
+// escape escapes characters of s which would otherwise be interpreted as Markdown.
+func (mw *mdWriter) escape(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		l = mdEscaper.Replace(l)
+		if mw.table {
+			l = strings.Replace(l, "|", `\|`, -1)
+		}
+		if i > 0 || mw.lineStart {
+			if mdLineStartRegexp.MatchString(l) {
+				l = `\` + l
+			}
+			l = mdOrderedRegexp.ReplaceAllString(l, `$1\.$2`)
+		}
+		lines[i] = l
 	}
-	i := sort.SearchStrings(v, mw.env)
-	return i < len(v) && v[i] == mw.env
+	return strings.Join(lines, "\n")
 }
 
 func (mw *mdWriter) write(nodes ...types.Node) error {
 	for _, n := range nodes {
-		if !mw.matchEnv(n.Env()) {
+		if !mw.env.Match(n.Env()) {
 			continue
 		}
 		switch n := n.(type) {
@@ -105,16 +222,16 @@ func (mw *mdWriter) write(nodes ...types.Node) error {
 			mw.write(n.Content.Nodes...)
 		case *types.ItemsListNode:
 			mw.itemsList(n)
-		//case *types.GridNode:
-		//	mw.grid(n)
-		//case *types.InfoboxNode:
-		//	mw.infobox(n)
-		//case *types.SurveyNode:
-		//	mw.survey(n)
+		case *types.GridNode:
+			mw.grid(n)
+		case *types.InfoboxNode:
+			mw.infobox(n)
+		case *types.SurveyNode:
+			mw.survey(n)
 		case *types.HeaderNode:
 			mw.header(n)
-			//case *types.YouTubeNode:
-			//	mw.youtube(n)
+		case *types.YouTubeNode:
+			mw.youtube(n)
 		}
 		if mw.err != nil {
 			return mw.err
@@ -124,31 +241,56 @@ func (mw *mdWriter) write(nodes ...types.Node) error {
 }
 
 func (mw *mdWriter) text(n *types.TextNode) {
+	// Emphasis markers must be adjacent to the text they surround.
+	v := strings.TrimSpace(n.Value)
+	if v == "" {
+		mw.writeString(n.Value)
+		return
+	}
+	i := strings.Index(n.Value, v)
+	mw.writeString(n.Value[:i])
 	if n.Bold {
-		mw.writeString("__")
+		mw.writeString("**")
 	}
 	if n.Italic {
-		mw.writeString(" *")
-	}
-	if n.Code {
-		mw.writeString("`")
+		mw.writeString("*")
 	}
-	mw.writeString(n.Value)
 	if n.Code {
-		mw.writeString("`")
+		mw.writeString(codeSpan(v))
+	} else {
+		mw.writeString(mw.escape(v))
 	}
 	if n.Italic {
-		mw.writeString("* ")
+		mw.writeString("*")
 	}
 	if n.Bold {
-		mw.writeString("__")
+		mw.writeString("**")
+	}
+	mw.writeString(n.Value[i+len(v):])
+}
+
+// codeSpan returns s as inline code, delimited by enough backticks
+// for any backticks of s to be part of the code.
+func codeSpan(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
 	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
 }
 
 func (mw *mdWriter) image(n *types.ImageNode) {
 	mw.space()
 	mw.writeString("![")
-	mw.writeString(path.Base(n.Src))
+	if mw.source && n.Alt != "" {
+		// Keep alternative text in sources, which lint checks for.
+		mw.writeString(mdEscaper.Replace(n.Alt))
+	} else {
+		mw.writeString(mdEscaper.Replace(path.Base(n.Src)))
+	}
 	mw.writeString("](")
 	mw.writeString(n.Src)
 	mw.writeString(")")
@@ -156,46 +298,37 @@ func (mw *mdWriter) image(n *types.ImageNode) {
 
 func (mw *mdWriter) url(n *types.URLNode) {
 	mw.space()
-	if n.URL != "" {
-		mw.writeString("[")
-	}
-	for _, cn := range n.Content.Nodes {
-		if t, ok := cn.(*types.TextNode); ok {
-			mw.writeString(t.Value)
-		}
-	}
-	if n.URL != "" {
-		mw.writeString("](")
-		mw.writeString(n.URL)
-		mw.writeString(")")
+	s := mw.render(n.Content.Nodes...)
+	if n.URL == "" {
+		mw.writeString(s)
+		return
 	}
+	mw.writeString("[")
+	mw.writeString(s)
+	mw.writeString("](")
+	mw.writeString(n.URL)
+	mw.writeString(")")
 }
 
 func (mw *mdWriter) code(n *types.CodeNode) {
 	mw.newBlock()
 	defer mw.writeBytes(newLine)
+	fence := "```"
+	for strings.Contains(n.Value, fence) {
+		fence += "`"
+	}
+	mw.writeString(fence)
 	if n.Term {
-		var buf bytes.Buffer
-		const prefix = "    "
-		lineStart := true
-		for _, r := range n.Value {
-			if lineStart {
-				buf.WriteString(prefix)
-			}
-			buf.WriteRune(r)
-			lineStart = r == '\n'
-		}
-		mw.writeBytes(buf.Bytes())
-		return
+		mw.writeString("console")
+	} else {
+		mw.writeString(n.Lang)
 	}
-	mw.writeString("```")
-	mw.writeString(n.Lang)
 	mw.writeBytes(newLine)
 	mw.writeString(n.Value)
 	if !mw.lineStart {
 		mw.writeBytes(newLine)
 	}
-	mw.writeString("```")
+	mw.writeString(fence)
 }
 
 func (mw *mdWriter) list(n *types.ListNode) {
@@ -216,19 +349,210 @@ func (mw *mdWriter) itemsList(n *types.ItemsListNode) {
 			s = strconv.Itoa(i+n.Start) + ". "
 		}
 		mw.writeString(s)
-		mw.write(item.Nodes...)
+		// Continuation lines, such as nested lists, are indented to the item content.
+		mw.writeString(indent(mw.render(item.Nodes...), strings.Repeat(" ", len(s))))
 		if !mw.lineStart {
 			mw.writeBytes(newLine)
 		}
 	}
 }
 
+// grid writes n as a table, its first row being the table header.
+// Markdown tables have no cells spanning multiple rows, so such cells
+// only appear in their first row. Cells spanning multiple columns are
+// followed by empty ones.
+func (mw *mdWriter) grid(n *types.GridNode) {
+	var ncols int
+	for _, r := range n.Rows {
+		var c int
+		for _, cell := range r {
+			c += colspan(cell)
+		}
+		if c > ncols {
+			ncols = c
+		}
+	}
+	if ncols == 0 {
+		return
+	}
+
+	mw.newBlock()
+	for i, r := range n.Rows {
+		mw.writeString("|")
+		var c int
+		for _, cell := range r {
+			mw.writeString(" ")
+			mw.writeString(mw.cell(cell))
+			mw.writeString(" |")
+			for j := 1; j < colspan(cell); j++ {
+				mw.writeString(" |")
+			}
+			c += colspan(cell)
+		}
+		for ; c < ncols; c++ {
+			mw.writeString(" |")
+		}
+		mw.writeBytes(newLine)
+		if i == 0 {
+			mw.writeString("|" + strings.Repeat(" --- |", ncols))
+			mw.writeBytes(newLine)
+		}
+	}
+}
+
+// cell renders content of c on a single line, separating its blocks with <br>.
+func (mw *mdWriter) cell(c *types.GridCell) string {
+	mw.table = true
+	s := mw.render(c.Content.Nodes...)
+	mw.table = false
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "<br>")
+}
+
+func colspan(c *types.GridCell) int {
+	if c.Colspan > 1 {
+		return c.Colspan
+	}
+	return 1
+}
+
+// directive writes [[name arg]] paragraph.
+func (mw *mdWriter) directive(name, arg string) {
+	mw.newBlock()
+	mw.writeString(strings.TrimSpace("[[" + name + " " + arg))
+	mw.writeString("]]")
+	mw.writeBytes(newLine)
+}
+
+// infobox writes n as a definition list, the way the Markdown parser reads infoboxes.
+// The term is the kind of n, and each block of its content is a definition.
+func (mw *mdWriter) infobox(n *types.InfoboxNode) {
+	mw.newBlock()
+	if n.Kind == types.InfoboxNegative {
+		mw.writeString("Negative")
+	} else {
+		mw.writeString("Positive")
+	}
+	mw.writeBytes(newLine)
+	def := func(nodes ...types.Node) {
+		if b := mw.render(nodes...); b != "" {
+			mw.writeString(": " + indent(b, "  "))
+			mw.writeBytes(newLine)
+		}
+	}
+	// consecutive inline nodes make up a single block
+	var inline []types.Node
+	for _, c := range n.Content.Nodes {
+		if isInline(c) {
+			inline = append(inline, c)
+			continue
+		}
+		if len(inline) > 0 {
+			def(inline...)
+			inline = nil
+		}
+		def(c)
+	}
+	if len(inline) > 0 {
+		def(inline...)
+	}
+}
+
+// isInline reports whether n is written within a block, rather than as one.
+func isInline(n types.Node) bool {
+	switch n := n.(type) {
+	case *types.TextNode, *types.URLNode, *types.ImageNode, *types.ButtonNode:
+		return true
+	case *types.ListNode:
+		return n.Block() != true
+	}
+	return false
+}
+
+// survey writes each question of n in bold, followed by a list of its options.
+// Sources use the [[survey]] directive, followed by questions in headers.
+func (mw *mdWriter) survey(n *types.SurveyNode) {
+	if mw.source {
+		mw.directive("survey", "")
+	}
+	for _, g := range n.Groups {
+		mw.newBlock()
+		if mw.source {
+			mw.writeString("#### " + mw.escape(g.Name))
+		} else {
+			mw.writeString("**" + mw.escape(g.Name) + "**")
+		}
+		mw.writeBytes(newLine)
+		mw.writeBytes(newLine)
+		for _, o := range g.Options {
+			mw.writeString("* " + mw.escape(o))
+			mw.writeBytes(newLine)
+		}
+	}
+}
+
+// header writes n one level deeper than its Level, or at its Level in sources.
+// Second level headers start steps in sources, so such headers are written
+// as third level ones there.
 func (mw *mdWriter) header(n *types.HeaderNode) {
 	mw.newBlock()
-	mw.writeString(strings.Repeat("#", n.Level+1))
+	l := n.Level + 1
+	if mw.source {
+		l = n.Level
+		if l < 3 {
+			l = 3
+		}
+	}
+	if l > 6 {
+		l = 6
+	}
+	mw.writeString(strings.Repeat("#", l))
 	mw.writeString(" ")
 	mw.write(n.Content.Nodes...)
 	if !mw.lineStart {
 		mw.writeBytes(newLine)
 	}
 }
+
+// youtube writes n as the video thumbnail linking to the video,
+// or as the [[youtube]] directive in sources.
+func (mw *mdWriter) youtube(n *types.YouTubeNode) {
+	if mw.source {
+		mw.directive("youtube", n.VideoID)
+		return
+	}
+	mw.newBlock()
+	mw.writeString("[![YouTube video](https://img.youtube.com/vi/" + n.VideoID + "/0.jpg)]")
+	mw.writeString("(https://www.youtube.com/watch?v=" + n.VideoID + ")")
+	mw.writeBytes(newLine)
+}
+
+// indent prefixes all lines of s but the first one with prefix.
+// Empty lines are left as is.
+func indent(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// equalStrings returns true if a and b contain the same strings in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
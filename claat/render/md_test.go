@@ -0,0 +1,73 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"testing"
+
+	"github.com/googlecodelabs/tools/claat/types"
+)
+
+func TestMD(t *testing.T) {
+	bold := types.NewTextNode("bold ")
+	bold.Bold = true
+	code := types.NewTextNode("a`b")
+	code.Code = true
+	para := types.NewListNode(bold, code)
+	para.MutateBlock(true)
+
+	items := types.NewItemsListNode("", 0)
+	items.NewItem(types.NewTextNode("one"), types.NewItemsListNode("", 0))
+	items.Items[0].Nodes[1].(*types.ItemsListNode).NewItem(types.NewTextNode("nested"))
+	items.NewItem(types.NewTextNode("two"))
+
+	tests := []struct {
+		node types.Node
+		out  string
+	}{
+		{types.NewTextNode("a *b* [c] 2_3"), `a \*b\* \[c\] 2\_3`},
+		{types.NewTextNode("# not a header\n1. not a list"), "\\# not a header\n1\\. not a list"},
+		{para, "\n**bold** ``a`b``\n"},
+		{types.NewURLNode("https://example.com", types.NewButtonNode(true, true, true, types.NewTextNode("Download"))),
+			"[Download](https://example.com)"},
+		{items, "\n* one\n\n  * nested\n* two\n"},
+		{types.NewCodeNode("$ ls\n", true), "\n```console\n$ ls\n```\n"},
+		{types.NewGridNode(
+			[]*types.GridCell{
+				{Colspan: 2, Content: types.NewListNode(types.NewTextNode("head"))},
+			},
+			[]*types.GridCell{
+				{Content: types.NewListNode(types.NewTextNode("a|b"))},
+				{Content: types.NewListNode(types.NewTextNode("c"))},
+			},
+		), "\n| head | |\n| --- | --- |\n| a\\|b | c |\n"},
+		{types.NewInfoboxNode(types.InfoboxNegative, types.NewTextNode("careful")), "\nNegative\n: careful\n"},
+		{types.NewInfoboxNode(types.InfoboxPositive, para, types.NewCodeNode("a\n\nb\n", false)),
+			"\nPositive\n: **bold** ``a`b``\n: ```\n  a\n\n  b\n  ```\n"},
+		{types.NewSurveyNode("id", &types.SurveyGroup{Name: "Q?", Options: []string{"a", "b"}}), "\n**Q?**\n\n* a\n* b\n"},
+		{types.NewYouTubeNode("vid"),
+			"\n[![YouTube video](https://img.youtube.com/vi/vid/0.jpg)](https://www.youtube.com/watch?v=vid)\n"},
+	}
+	for i, test := range tests {
+		out, err := MD("", test.node)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("%d: MD(%v) = %q; want %q", i, test.node.Type(), out, test.out)
+		}
+	}
+}
@@ -71,13 +71,7 @@ var funcMap = map[string]interface{}{
 	"renderLite": Lite,
 	"renderHTML": HTML,
 	"renderMD":   MD,
-	"matchEnv": func(tags []string, t string) bool {
-		if len(tags) == 0 || t == "" {
-			return true
-		}
-		i := sort.SearchStrings(tags, t)
-		return i < len(tags) && tags[i] == t
-	},
+	"matchEnv":   MatchEnv,
 	// lite/offline versions; multiple step files
 	"inc": func(n int) int {
 		return n + 1
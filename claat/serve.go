@@ -0,0 +1,257 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reloadPath is the server-sent events endpoint open pages
+	// listen on for reload signals.
+	reloadPath = "/_claat/reload"
+	// watchInterval is how often codelab sources, and local files
+	// they are made of, are checked for changes.
+	watchInterval = 500 * time.Millisecond
+)
+
+// reloadScript is injected into every served HTML page.
+// It reloads the page when the server signals a codelab has been re-exported.
+var reloadScript = []byte(`<script>
+(function() {
+  var es = new EventSource("` + reloadPath + `");
+  es.onmessage = function() { location.reload(); };
+})();
+</script>
+`)
+
+// cmdServe is the "claat serve ..." subcommand.
+func cmdServe() {
+	if flag.NArg() == 0 {
+		fatalf("Need at least one source. Try '-h' for options.")
+	}
+	if isStdout(*output) {
+		fatalf("Serve needs an output directory; stdout is not supported.")
+	}
+	args := unique(flag.Args())
+	for _, src := range args {
+		if _, err := os.Stat(src); err != nil {
+			fatalf("%s: only local files can be served: %v", src, err)
+		}
+	}
+
+	rl := newReloader()
+	for _, src := range args {
+		files := serveExport(src)
+		go watchSource(src, files, watchInterval, rl.reload)
+	}
+
+	http.Handle("/", &previewHandler{root: http.Dir(*output)})
+	http.Handle(reloadPath, rl)
+	printf("Serving %s at http://%s", *output, *addr)
+	fatalf("%v", http.ListenAndServe(*addr, nil))
+}
+
+// serveExport exports src the same way exportCodelab does and reports the result.
+// Unlike cmdExport, a failure does not affect the exit code,
+// since the source will be re-exported on the next change.
+// It returns local files the codelab is made of, as recorded
+// by slurpCodelab, or nil if the export failed.
+func serveExport(src string) []string {
+	clab, err := slurpCodelab(src)
+	if err != nil {
+		printf(reportErr, src, err)
+		return nil
+	}
+	meta, err := storeCodelab(src, clab)
+	if err != nil {
+		printf(reportErr, src, err)
+		return nil
+	}
+	printf(reportOk, meta.ID)
+	return clab.files
+}
+
+// watchSource polls src and files every d interval, re-exports src
+// with serveExport when any of them changes, and calls fn on success.
+// The files are those the codelab is made of, such as imported fragments
+// and images. They are replaced with the result of each successful export,
+// so that newly imported files are watched too.
+// It never returns.
+func watchSource(src string, files []string, d time.Duration, fn func()) {
+	w := newFileWatcher(append(files, src))
+	for range time.Tick(d) {
+		if !w.changed() {
+			continue
+		}
+		if files := serveExport(src); files != nil {
+			w.watch(append(files, src))
+			fn()
+		}
+	}
+}
+
+// fileWatcher detects changes of a set of files
+// by polling their modification time.
+type fileWatcher struct {
+	mod map[string]time.Time // last seen modification time; zero if the file is missing
+}
+
+// newFileWatcher creates a watcher of files.
+func newFileWatcher(files []string) *fileWatcher {
+	w := &fileWatcher{}
+	w.watch(files)
+	return w
+}
+
+// watch replaces watched files with files,
+// recording their current modification time.
+func (w *fileWatcher) watch(files []string) {
+	w.mod = make(map[string]time.Time, len(files))
+	for _, f := range files {
+		w.mod[f] = modTime(f)
+	}
+}
+
+// changed reports whether any of the watched files has been modified,
+// created or removed since the previous call, or since it started being watched.
+func (w *fileWatcher) changed() bool {
+	var changed bool
+	for f, t := range w.mod {
+		if m := modTime(f); !m.Equal(t) {
+			w.mod[f] = m
+			changed = true
+		}
+	}
+	return changed
+}
+
+// modTime returns modification time of file, or zero time if it does not exist.
+func modTime(file string) time.Time {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// reloader is an http.Handler which streams reload signals
+// to connected pages using server-sent events.
+type reloader struct {
+	mu      sync.Mutex // guards clients
+	clients map[chan struct{}]struct{}
+}
+
+func newReloader() *reloader {
+	return &reloader{clients: make(map[chan struct{}]struct{})}
+}
+
+// reload signals all connected clients to reload.
+func (rl *reloader) reload() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for c := range rl.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+			// a reload is already pending for this client
+		}
+	}
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan struct{}, 1)
+	rl.mu.Lock()
+	rl.clients[c] = struct{}{}
+	rl.mu.Unlock()
+	defer func() {
+		rl.mu.Lock()
+		delete(rl.clients, c)
+		rl.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	for {
+		select {
+		case <-c:
+			fmt.Fprint(w, "data: reload\n\n")
+			f.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// previewHandler serves exported codelabs from root.
+// HTML pages are served with reloadScript injected,
+// everything else is handled by http.FileServer.
+type previewHandler struct {
+	root http.FileSystem
+}
+
+func (h *previewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if path.Ext(name) != ".html" {
+		http.FileServer(h.root).ServeHTTP(w, r)
+		return
+	}
+	f, err := h.root.Open(name)
+	if err != nil {
+		http.FileServer(h.root).ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(injectReload(b))
+}
+
+// injectReload inserts reloadScript right before the closing </body> tag,
+// or appends it if the page has no body end tag.
+func injectReload(page []byte) []byte {
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, reloadScript...)
+	}
+	res := make([]byte, 0, len(page)+len(reloadScript))
+	res = append(res, page[:i]...)
+	res = append(res, reloadScript...)
+	return append(res, page[i:]...)
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInjectReload(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"<html><body>hi</body></html>", "<html><body>hi" + string(reloadScript) + "</body></html>"},
+		{"no body", "no body" + string(reloadScript)},
+	}
+	for i, test := range tests {
+		out := string(injectReload([]byte(test.in)))
+		if out != test.out {
+			t.Errorf("%d: injectReload(%q) = %q; want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "lab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"lab/index.html":   "<body>page</body>",
+		"lab/codelab.json": "{}",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := httptest.NewServer(&previewHandler{root: http.Dir(dir)})
+	defer ts.Close()
+	tests := []struct {
+		path   string
+		reload bool
+	}{
+		{"/lab/", true},
+		{"/lab/index.html", true},
+		{"/lab/codelab.json", false},
+	}
+	for _, test := range tests {
+		res, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: status = %d; want 200", test.path, res.StatusCode)
+		}
+		if v := bytes.Contains(b, []byte(reloadPath)); v != test.reload {
+			t.Errorf("%s: has reload script = %v; want %v", test.path, v, test.reload)
+		}
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.md")
+	b := filepath.Join(dir, "b.md")
+	if err := ioutil.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFileWatcher([]string{a, b})
+	if w.changed() {
+		t.Errorf("changed() = true; want false before any modification")
+	}
+	// b did not exist when watching started
+	if err := ioutil.WriteFile(b, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.changed() {
+		t.Errorf("changed() = false; want true after b is created")
+	}
+	if w.changed() {
+		t.Errorf("changed() = true; want false on a repeated call")
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(a, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if !w.changed() {
+		t.Errorf("changed() = false; want true after a is modified")
+	}
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if !w.changed() {
+		t.Errorf("changed() = false; want true after b is removed")
+	}
+}
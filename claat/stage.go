@@ -0,0 +1,262 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stagePrefix is the name prefix of staging and backup directories,
+// created next to codelab directories while they are being written.
+// Such directories are skipped when scanning for codelabs.
+const stagePrefix = ".claat-"
+
+// stageDir creates an empty staging directory for codelab dir, next to it,
+// so that the two are on the same file system and can be swapped with
+// commitDir. Use carryOver to keep contents of dir which are not written
+// into the staging directory.
+//
+// If dir is missing because a previous commitDir has been interrupted,
+// it is restored from its backup first. See restoreBackup.
+//
+// The caller is responsible for removing the staging directory
+// if it is not committed.
+func stageDir(dir string) (string, error) {
+	dir = filepath.Clean(dir)
+	parent, name := filepath.Split(dir)
+	if parent == "" {
+		parent = "."
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	if err := restoreBackup(dir); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(parent, stagePrefix+"stage-"+name+"-")
+}
+
+// carryOver adds top-level entries of dir which are missing in staged,
+// a staging directory of dir, to staged. This makes committing staged
+// equivalent to replacing only the entries written into it, while
+// a directory written into staged, such as the images dir, replaces
+// the one in dir entirely.
+//
+// Files are hard-linked rather than copied, where the file system allows,
+// so the cost does not depend on their size. staged must not be written
+// to afterwards, since its files may share contents with the ones in dir.
+// A missing dir is not an error.
+func carryOver(staged, dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		dst := filepath.Join(staged, fi.Name())
+		if _, err := os.Lstat(dst); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := linkAll(dst, filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkAll hard-links regular file src to dst, or each file of
+// directory src into dst, recursively. Files which cannot be linked
+// are copied. Symbolic links are not followed.
+func linkAll(dst, src string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, 0755)
+		case fi.Mode().IsRegular():
+			if os.Link(p, target) == nil {
+				return nil
+			}
+			return copyFile(target, p, fi.Mode())
+		}
+		return nil
+	})
+}
+
+// commitDir replaces dir with staged directory, created with stageDir.
+//
+// The swap consists of two renames: dir is moved aside into a backup named
+// after stagePrefix, "old-" and dir, and staged takes its place, after which
+// the backup is removed. Should the second rename fail, the original dir
+// is moved back. Readers never see a mix of old and new contents, but the swap
+// is not atomic: dir does not exist between the two renames. If the process
+// is interrupted there, dir is left in its backup, which the next stageDir
+// of dir restores.
+func commitDir(staged, dir string) error {
+	dir = filepath.Clean(dir)
+	if err := os.Chmod(staged, 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return os.Rename(staged, dir)
+	}
+	parent, name := filepath.Split(dir)
+	if parent == "" {
+		parent = "."
+	}
+	backup, err := ioutil.TempDir(parent, stagePrefix+"old-"+name+"-")
+	if err != nil {
+		return err
+	}
+	// rename does not replace a directory on all systems
+	if err := os.Remove(backup); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, dir); err != nil {
+		if rerr := os.Rename(backup, dir); rerr != nil {
+			return fmt.Errorf("%v; original left in %s: %v", err, backup, rerr)
+		}
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+// restoreBackup moves the most recent backup of dir, left by an interrupted
+// commitDir, back in place. It does nothing if dir exists.
+func restoreBackup(dir string) error {
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		return err
+	}
+	parent, name := filepath.Split(dir)
+	if parent == "" {
+		parent = "."
+	}
+	fis, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return err
+	}
+	var backup os.FileInfo
+	prefix := stagePrefix + "old-" + name + "-"
+	for _, fi := range fis {
+		// the rest is a random number added by ioutil.TempDir,
+		// otherwise it's a backup of another dir, e.g. name-web
+		suffix := strings.TrimPrefix(fi.Name(), prefix)
+		if !fi.IsDir() || len(suffix) == len(fi.Name()) || strings.Trim(suffix, "0123456789") != "" {
+			continue
+		}
+		if backup == nil || fi.ModTime().After(backup.ModTime()) {
+			backup = fi
+		}
+	}
+	if backup == nil {
+		return nil
+	}
+	return os.Rename(filepath.Join(parent, backup.Name()), dir)
+}
+
+// writeStaged calls write with a staging directory of dir, created
+// with stageDir, and commits it in place of dir if write succeeds.
+// Otherwise, dir is left untouched and the staging directory is removed.
+// Entries of dir which write does not create are kept, see carryOver.
+func writeStaged(dir string, write func(staged string) error) error {
+	return stageAndCommit(dir, true, write)
+}
+
+// replaceStaged is like writeStaged, except the staging directory starts
+// empty, so that the contents of dir are replaced entirely.
+func replaceStaged(dir string, write func(staged string) error) error {
+	return stageAndCommit(dir, false, write)
+}
+
+func stageAndCommit(dir string, keep bool, write func(staged string) error) error {
+	staged, err := stageDir(dir)
+	if err != nil {
+		return err
+	}
+	// staged no longer exists once committed
+	defer os.RemoveAll(staged)
+	if err := write(staged); err != nil {
+		return err
+	}
+	if keep {
+		if err := carryOver(staged, dir); err != nil {
+			return err
+		}
+	}
+	return commitDir(staged, dir)
+}
+
+// writeFileAtomic writes b to file, replacing it atomically
+// by renaming a temporary file written next to it.
+func writeFileAtomic(file string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), stagePrefix+filepath.Base(file)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// isStageDir reports whether name is a staging or backup directory name.
+func isStageDir(name string) bool {
+	return strings.HasPrefix(name, stagePrefix)
+}
+
+// copyFile copies regular file src to dst, with the specified mode.
+func copyFile(dst, src string, mode os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
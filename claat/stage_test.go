@@ -0,0 +1,179 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteStaged(t *testing.T) {
+	root, err := ioutil.TempDir("", "claat-stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "lab")
+	if err := os.MkdirAll(filepath.Join(dir, imgDirname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"index.html":                       "old",
+		"index.md":                         "other format",
+		filepath.Join(imgDirname, "a.png"): "png",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	leftovers := func() {
+		fis, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if !reflect.DeepEqual(names, []string{"lab"}) {
+			t.Errorf("root dir contains %q; want only lab", names)
+		}
+	}
+
+	fail := errors.New("step 7 failed")
+	err = writeStaged(dir, func(staged string) error {
+		if err := ioutil.WriteFile(filepath.Join(staged, "index.html"), []byte("half"), 0644); err != nil {
+			return err
+		}
+		return fail
+	})
+	if err != fail {
+		t.Errorf("writeStaged: %v; want %v", err, fail)
+	}
+	if v := read("index.html"); v != "old" {
+		t.Errorf("index.html = %q after a failure; want old", v)
+	}
+	leftovers()
+
+	err = writeStaged(dir, func(staged string) error {
+		return ioutil.WriteFile(filepath.Join(staged, "index.html"), []byte("new"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := read("index.html"); v != "new" {
+		t.Errorf("index.html = %q; want new", v)
+	}
+	if v := read("index.md"); v != "other format" {
+		t.Errorf("index.md = %q; want it preserved", v)
+	}
+	if v := read(filepath.Join(imgDirname, "a.png")); v != "png" {
+		t.Errorf("img/a.png = %q; want it preserved", v)
+	}
+	leftovers()
+
+	// a dir written into staged replaces the original
+	err = writeStaged(dir, func(staged string) error {
+		if err := os.MkdirAll(filepath.Join(staged, imgDirname), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(staged, imgDirname, "b.png"), []byte("png"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := read(filepath.Join(imgDirname, "b.png")); v != "png" {
+		t.Errorf("img/b.png = %q; want png", v)
+	}
+	if _, err := os.Stat(filepath.Join(dir, imgDirname, "a.png")); !os.IsNotExist(err) {
+		t.Errorf("img/a.png: %v; want it removed", err)
+	}
+	if v := read("index.html"); v != "new" {
+		t.Errorf("index.html = %q; want it preserved", v)
+	}
+	leftovers()
+}
+
+func TestStageDirRestoresBackup(t *testing.T) {
+	root, err := ioutil.TempDir("", "claat-stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// lab was moved aside by an interrupted commitDir;
+	// the other backup belongs to lab-web
+	backups := map[string]string{
+		stagePrefix + "old-lab-123":     "lab",
+		stagePrefix + "old-lab-web-456": "lab-web",
+	}
+	for name, content := range backups {
+		if err := os.MkdirAll(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, name, "index.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(root, "lab")
+	err = writeStaged(dir, func(staged string) error {
+		return ioutil.WriteFile(filepath.Join(staged, "index.html"), []byte("new"), 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "index.md"))
+	if err != nil || string(b) != "lab" {
+		t.Errorf("index.md = %q, %v; want lab restored from backup", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, stagePrefix+"old-lab-web-456")); err != nil {
+		t.Errorf("backup of lab-web: %v", err)
+	}
+}
+
+func TestWalkPathSkipsStaging(t *testing.T) {
+	root, err := ioutil.TempDir("", "claat-stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, d := range []string{"lab", stagePrefix + "stage-lab-123"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, d, metaFilename), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirs, err := walkPath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(root, "lab")}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("walkPath(%q) = %q; want %q", root, dirs, want)
+	}
+}
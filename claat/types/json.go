@@ -0,0 +1,463 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JSONVersion is the version of the codelab JSON schema.
+// It is incremented on every incompatible change of the schema.
+//
+// A codelab is encoded as an object with the following fields:
+//
+//	version  JSONVersion
+//	meta     Meta, encoded the same way as in codelab.json metadata files
+//	extra    unrecognized metadata, an object of strings; omitted if empty
+//	steps    array of steps
+//
+// Each step is an object with "title", "tags", "duration" in minutes
+// and "content" fields, where content is a node of type "list".
+//
+// A node is an object with a "type" field, one of the NodeType names
+// returned by NodeType.String, and the following optional fields
+// common to all nodes:
+//
+//	env    array of environment tags
+//	block  true if the node is a block, as opposed to an inline node
+//	pos    source position: an object with "line", "column" and "elem" fields
+//
+// The rest of the node fields depend on its type and are omitted
+// when they have zero value:
+//
+//	list                                    nodes: array of nodes
+//	grid                                    rows: array of arrays of cells, each with "colspan", "rowspan" and "content"
+//	text                                    value, bold, italic, code
+//	code                                    value, term, lang
+//	infobox                                 kind, content
+//	survey                                  id, groups: array of objects with "name" and "options"
+//	url                                     url, name, target, content
+//	image                                   src, alt, maxWidth
+//	button                                  raised, colored, download, content
+//	itemslist, itemscheck, itemsfaq         listType, start, items: array of list nodes
+//	header, headercheck, headerfaq          level, content
+//	youtube                                 videoId
+//	import                                  url, content
+//
+// Fields named content contain a node of type "list".
+//
+// A codelab fragment is encoded as an array of nodes.
+const JSONVersion = 1
+
+// nodeTypeNames are the names of node types used in the JSON encoding.
+var nodeTypeNames = map[NodeType]string{
+	NodeList:        "list",
+	NodeGrid:        "grid",
+	NodeText:        "text",
+	NodeCode:        "code",
+	NodeInfobox:     "infobox",
+	NodeSurvey:      "survey",
+	NodeURL:         "url",
+	NodeImage:       "image",
+	NodeButton:      "button",
+	NodeItemsList:   "itemslist",
+	NodeItemsCheck:  "itemscheck",
+	NodeItemsFAQ:    "itemsfaq",
+	NodeHeader:      "header",
+	NodeHeaderCheck: "headercheck",
+	NodeHeaderFAQ:   "headerfaq",
+	NodeYouTube:     "youtube",
+	NodeImport:      "import",
+}
+
+// String returns the name of t, as used in the JSON encoding,
+// or "invalid" if t is not a known node type.
+func (t NodeType) String() string {
+	if s, ok := nodeTypeNames[t]; ok {
+		return s
+	}
+	return "invalid"
+}
+
+// jsonCodelab is the JSON encoding of Codelab.
+type jsonCodelab struct {
+	Version int               `json:"version"`
+	Meta    Meta              `json:"meta"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	Steps   []*jsonStep       `json:"steps"`
+}
+
+// jsonStep is the JSON encoding of Step.
+type jsonStep struct {
+	Title    string    `json:"title"`
+	Tags     []string  `json:"tags"`
+	Duration int       `json:"duration"` // minutes
+	Content  *jsonNode `json:"content"`
+}
+
+// jsonNode is the JSON encoding of all node types.
+// See JSONVersion for details.
+type jsonNode struct {
+	Type  string   `json:"type"`
+	Env   []string `json:"env,omitempty"`
+	Block bool     `json:"block,omitempty"`
+	Pos   *Pos     `json:"pos,omitempty"`
+
+	Nodes    []*jsonNode        `json:"nodes,omitempty"`
+	Content  *jsonNode          `json:"content,omitempty"`
+	Rows     [][]*jsonCell      `json:"rows,omitempty"`
+	Items    []*jsonNode        `json:"items,omitempty"`
+	Groups   []*jsonSurveyGroup `json:"groups,omitempty"`
+	Value    string             `json:"value,omitempty"`
+	Bold     bool               `json:"bold,omitempty"`
+	Italic   bool               `json:"italic,omitempty"`
+	Code     bool               `json:"code,omitempty"`
+	Term     bool               `json:"term,omitempty"`
+	Lang     string             `json:"lang,omitempty"`
+	Kind     InfoboxKind        `json:"kind,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	URL      string             `json:"url,omitempty"`
+	Name     string             `json:"name,omitempty"`
+	Target   string             `json:"target,omitempty"`
+	Src      string             `json:"src,omitempty"`
+	Alt      string             `json:"alt,omitempty"`
+	MaxWidth float32            `json:"maxWidth,omitempty"`
+	Raised   bool               `json:"raised,omitempty"`
+	Colored  bool               `json:"colored,omitempty"`
+	Download bool               `json:"download,omitempty"`
+	ListType string             `json:"listType,omitempty"`
+	Start    int                `json:"start,omitempty"`
+	Level    int                `json:"level,omitempty"`
+	VideoID  string             `json:"videoId,omitempty"`
+}
+
+// jsonCell is the JSON encoding of GridCell.
+type jsonCell struct {
+	Colspan int       `json:"colspan"`
+	Rowspan int       `json:"rowspan"`
+	Content *jsonNode `json:"content"`
+}
+
+// jsonSurveyGroup is the JSON encoding of SurveyGroup.
+type jsonSurveyGroup struct {
+	Name    string   `json:"name"`
+	Options []string `json:"options"`
+}
+
+// MarshalJSON implements Marshaler interface.
+// The encoding is described in JSONVersion.
+func (c *Codelab) MarshalJSON() ([]byte, error) {
+	jc := &jsonCodelab{
+		Version: JSONVersion,
+		Meta:    c.Meta,
+		Extra:   c.Extra,
+		Steps:   make([]*jsonStep, 0, len(c.Steps)),
+	}
+	for _, s := range c.Steps {
+		js := &jsonStep{
+			Title:    s.Title,
+			Tags:     s.Tags,
+			Duration: int(s.Duration / time.Minute),
+			Content:  encodeList(s.Content),
+		}
+		if js.Tags == nil {
+			js.Tags = []string{}
+		}
+		jc.Steps = append(jc.Steps, js)
+	}
+	return json.Marshal(jc)
+}
+
+// UnmarshalJSON implements Unmarshaler interface.
+// The encoding is described in JSONVersion.
+func (c *Codelab) UnmarshalJSON(b []byte) error {
+	var jc jsonCodelab
+	if err := json.Unmarshal(b, &jc); err != nil {
+		return err
+	}
+	if jc.Version != JSONVersion {
+		return fmt.Errorf("unsupported codelab JSON version %d; want %d", jc.Version, JSONVersion)
+	}
+	c.Meta = jc.Meta
+	c.Extra = jc.Extra
+	c.Steps = make([]*Step, 0, len(jc.Steps))
+	for i, js := range jc.Steps {
+		content, err := decodeList(js.Content)
+		if err != nil {
+			return fmt.Errorf("step %d: %v", i+1, err)
+		}
+		c.Steps = append(c.Steps, &Step{
+			Title:    js.Title,
+			Tags:     js.Tags,
+			Duration: time.Duration(js.Duration) * time.Minute,
+			Content:  content,
+		})
+	}
+	return nil
+}
+
+// MarshalNodes returns the JSON encoding of a codelab fragment made of nodes.
+// The encoding is described in JSONVersion.
+func MarshalNodes(nodes []Node) ([]byte, error) {
+	return json.Marshal(encodeNodes(nodes))
+}
+
+// UnmarshalNodes parses a codelab fragment encoded with MarshalNodes.
+func UnmarshalNodes(b []byte) ([]Node, error) {
+	var jnodes []*jsonNode
+	if err := json.Unmarshal(b, &jnodes); err != nil {
+		return nil, err
+	}
+	return decodeNodes(jnodes)
+}
+
+func encodeNodes(nodes []Node) []*jsonNode {
+	res := make([]*jsonNode, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, encodeNode(n))
+	}
+	return res
+}
+
+// encodeList is the same as encodeNode, except that a nil l results in an empty list node.
+func encodeList(l *ListNode) *jsonNode {
+	if l == nil {
+		return &jsonNode{Type: NodeList.String()}
+	}
+	return encodeNode(l)
+}
+
+// encodeNode converts n into its JSON representation, recursively.
+func encodeNode(n Node) *jsonNode {
+	jn := &jsonNode{
+		Type:  n.Type().String(),
+		Env:   n.Env(),
+		Block: n.Block() == true,
+	}
+	if p := n.Pos(); p.IsValid() {
+		jn.Pos = &p
+	}
+	switch n := n.(type) {
+	case *ListNode:
+		jn.Nodes = encodeNodes(n.Nodes)
+	case *ImportNode:
+		jn.URL = n.URL
+		jn.Content = encodeList(n.Content)
+	case *GridNode:
+		jn.Rows = make([][]*jsonCell, 0, len(n.Rows))
+		for _, r := range n.Rows {
+			row := make([]*jsonCell, 0, len(r))
+			for _, c := range r {
+				row = append(row, &jsonCell{
+					Colspan: c.Colspan,
+					Rowspan: c.Rowspan,
+					Content: encodeList(c.Content),
+				})
+			}
+			jn.Rows = append(jn.Rows, row)
+		}
+	case *ItemsListNode:
+		jn.ListType = n.ListType
+		jn.Start = n.Start
+		for _, it := range n.Items {
+			jn.Items = append(jn.Items, encodeList(it))
+		}
+	case *TextNode:
+		jn.Value = n.Value
+		jn.Bold = n.Bold
+		jn.Italic = n.Italic
+		jn.Code = n.Code
+	case *CodeNode:
+		jn.Value = n.Value
+		jn.Term = n.Term
+		jn.Lang = n.Lang
+	case *HeaderNode:
+		jn.Level = n.Level
+		jn.Content = encodeList(n.Content)
+	case *URLNode:
+		jn.URL = n.URL
+		jn.Name = n.Name
+		jn.Target = n.Target
+		jn.Content = encodeList(n.Content)
+	case *ImageNode:
+		jn.Src = n.Src
+		jn.Alt = n.Alt
+		jn.MaxWidth = n.MaxWidth
+	case *ButtonNode:
+		jn.Raised = n.Raised
+		jn.Colored = n.Colored
+		jn.Download = n.Download
+		jn.Content = encodeList(n.Content)
+	case *SurveyNode:
+		jn.ID = n.ID
+		for _, g := range n.Groups {
+			jn.Groups = append(jn.Groups, &jsonSurveyGroup{Name: g.Name, Options: g.Options})
+		}
+	case *InfoboxNode:
+		jn.Kind = n.Kind
+		jn.Content = encodeList(n.Content)
+	case *YouTubeNode:
+		jn.VideoID = n.VideoID
+	}
+	return jn
+}
+
+func decodeNodes(jnodes []*jsonNode) ([]Node, error) {
+	nodes := make([]Node, 0, len(jnodes))
+	for _, jn := range jnodes {
+		n, err := decodeNode(jn)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// decodeList is the same as decodeNode, except that jn must be of type NodeList.
+// A nil jn results in an empty list.
+func decodeList(jn *jsonNode) (*ListNode, error) {
+	if jn == nil {
+		return NewListNode(), nil
+	}
+	n, err := decodeNode(jn)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := n.(*ListNode)
+	if !ok {
+		return nil, fmt.Errorf("%s node where list is expected", n.Type())
+	}
+	return l, nil
+}
+
+// decodeNode converts jn into a node, recursively.
+// It is the reverse of encodeNode.
+func decodeNode(jn *jsonNode) (Node, error) {
+	if jn == nil {
+		return nil, fmt.Errorf("null node")
+	}
+	var typ NodeType
+	for t, name := range nodeTypeNames {
+		if name == jn.Type {
+			typ = t
+			break
+		}
+	}
+
+	var n Node
+	var err error
+	switch typ {
+	case NodeList:
+		l := NewListNode()
+		l.Nodes, err = decodeNodes(jn.Nodes)
+		n = l
+	case NodeImport:
+		in := NewImportNode(jn.URL)
+		in.Content, err = decodeList(jn.Content)
+		n = in
+	case NodeGrid:
+		rows := make([][]*GridCell, 0, len(jn.Rows))
+		for _, r := range jn.Rows {
+			row := make([]*GridCell, 0, len(r))
+			for _, c := range r {
+				if c == nil {
+					return nil, fmt.Errorf("null grid cell")
+				}
+				content, err := decodeList(c.Content)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, &GridCell{Colspan: c.Colspan, Rowspan: c.Rowspan, Content: content})
+			}
+			rows = append(rows, row)
+		}
+		n = NewGridNode(rows...)
+	case NodeItemsList, NodeItemsCheck, NodeItemsFAQ:
+		il := NewItemsListNode(jn.ListType, jn.Start)
+		il.MutateType(typ)
+		for _, it := range jn.Items {
+			l, err := decodeList(it)
+			if err != nil {
+				return nil, err
+			}
+			il.Items = append(il.Items, l)
+		}
+		n = il
+	case NodeText:
+		t := NewTextNode(jn.Value)
+		t.Bold = jn.Bold
+		t.Italic = jn.Italic
+		t.Code = jn.Code
+		n = t
+	case NodeCode:
+		c := NewCodeNode(jn.Value, jn.Term)
+		c.Lang = jn.Lang
+		n = c
+	case NodeHeader, NodeHeaderCheck, NodeHeaderFAQ:
+		h := NewHeaderNode(jn.Level)
+		h.MutateType(typ)
+		h.Content, err = decodeList(jn.Content)
+		n = h
+	case NodeURL:
+		u := NewURLNode(jn.URL)
+		u.Name = jn.Name
+		u.Target = jn.Target
+		u.Content, err = decodeList(jn.Content)
+		n = u
+	case NodeImage:
+		img := NewImageNode(jn.Src)
+		img.Alt = jn.Alt
+		img.MaxWidth = jn.MaxWidth
+		n = img
+	case NodeButton:
+		b := NewButtonNode(jn.Raised, jn.Colored, jn.Download)
+		b.Content, err = decodeList(jn.Content)
+		n = b
+	case NodeSurvey:
+		sn := NewSurveyNode(jn.ID)
+		for _, g := range jn.Groups {
+			if g == nil {
+				return nil, fmt.Errorf("null survey group")
+			}
+			sn.Groups = append(sn.Groups, &SurveyGroup{Name: g.Name, Options: g.Options})
+		}
+		n = sn
+	case NodeInfobox:
+		ib := NewInfoboxNode(jn.Kind)
+		ib.Content, err = decodeList(jn.Content)
+		n = ib
+	case NodeYouTube:
+		n = NewYouTubeNode(jn.VideoID)
+	default:
+		return nil, fmt.Errorf("unknown node type %q", jn.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jn.Env) > 0 {
+		n.MutateEnv(jn.Env)
+	}
+	if jn.Block {
+		n.MutateBlock(true)
+	}
+	if jn.Pos != nil {
+		n.MutatePos(*jn.Pos)
+	}
+	return n, nil
+}
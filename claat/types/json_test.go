@@ -0,0 +1,56 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCodelabMarshalJSON(t *testing.T) {
+	c := &Codelab{Meta: Meta{ID: "lab", Title: "Lab"}}
+	s := c.NewStep("Step")
+	s.Duration = 90 * time.Minute
+	s.Tags = []string{"web"}
+
+	p := NewListNode(NewTextNode("Hello "), NewURLNode("https://example.com", NewTextNode("world")))
+	p.MutateBlock(true)
+	p.MutatePos(Pos{Line: 7, Column: 1})
+	h := NewHeaderNode(3, NewTextNode("What you'll learn"))
+	h.MutateType(NodeHeaderCheck)
+	h.MutateEnv([]string{"web"})
+	il := NewItemsListNode("", 0)
+	il.MutateType(NodeItemsCheck)
+	il.NewItem(NewTextNode("one"))
+	s.Content.Append(p, h, il, NewYouTubeNode("vid"))
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"version":1,` +
+		`"meta":{"id":"lab","duration":0,"title":"Lab","summary":"","theme":"","status":null,"category":null,"tags":null,"url":""},` +
+		`"steps":[{"title":"Step","tags":["web"],"duration":90,"content":{"type":"list","nodes":[` +
+		`{"type":"list","block":true,"pos":{"line":7,"column":1},"nodes":[` +
+		`{"type":"text","value":"Hello "},` +
+		`{"type":"url","content":{"type":"list","nodes":[{"type":"text","value":"world"}]},"url":"https://example.com","target":"_blank"}]},` +
+		`{"type":"headercheck","env":["web"],"content":{"type":"list","nodes":[{"type":"text","value":"What you'll learn"}]},"level":3},` +
+		`{"type":"itemscheck","items":[{"type":"list","nodes":[{"type":"text","value":"one"}]}]},` +
+		`{"type":"youtube","videoId":"vid"}]}}]}`
+	if string(b) != want {
+		t.Errorf("json.Marshal(c) = %s; want %s", b, want)
+	}
+}
@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -39,15 +41,41 @@ type Meta struct {
 	URL string `json:"url"` // Legacy ID; TODO: remove
 }
 
+// idRegexp is the grammar of codelab IDs. See NormalizeID.
+var idRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// NormalizeID trims surrounding space of codelab ID id, replaces inner runs
+// of space with a dash, and validates the result.
+//
+// A valid ID starts with a letter or a digit, followed by letters, digits,
+// '.', '_' and '-'. It is thus safe to use as a single path element
+// of a codelab URL or output directory: it contains no path separators,
+// can't be "." or "..", and isn't a hidden file name.
+// An empty ID is returned as is, leaving it to the caller to treat it as missing.
+func NormalizeID(id string) (string, error) {
+	id = strings.Join(strings.Fields(id), "-")
+	if id != "" && !idRegexp.MatchString(id) {
+		return "", fmt.Errorf("invalid codelab ID %q: must start with a letter or digit, followed by letters, digits, '.', '_' or '-'", id)
+	}
+	return id, nil
+}
+
 // Context is an export context.
 // It is defined in this package so that it can be used by both cli and a server.
 type Context struct {
-	Env     string       `json:"environment"`       // Current export environment
-	Source  string       `json:"source"`            // Codelab source doc
-	Format  string       `json:"format"`            // Output format, e.g. "html"
-	Prefix  string       `json:"prefix,omitempty"`  // Assets URL prefix for HTML-based formats
-	MainGA  string       `json:"mainga,omitempty"`  // Global Google Analytics ID
-	Updated *ContextTime `json:"updated,omitempty"` // Last update timestamp
+	Env     string       `json:"environment"`            // Current export environment
+	Envs    []string     `json:"environments,omitempty"` // All environments exported along with Env
+	EnvDir  string       `json:"envdir,omitempty"`       // Environment dir naming scheme, see -envdir
+	Source  string       `json:"source"`                 // Codelab source doc
+	Format  string       `json:"format"`                 // Output format, e.g. "html"
+	Formats []string     `json:"formats,omitempty"`      // All output formats, Format being the first
+	Prefix  string       `json:"prefix,omitempty"`       // Assets URL prefix for HTML-based formats
+	MainGA  string       `json:"mainga,omitempty"`       // Global Google Analytics ID
+	Updated *ContextTime `json:"updated,omitempty"`      // Last update timestamp
+	Hash    string       `json:"hash,omitempty"`         // Hash of parsed codelab content, imports included
+	Imports []string     `json:"imports,omitempty"`      // Fragments imported by the codelab source
+	// Images maps image files of the codelab dir to the URLs they were downloaded from.
+	Images map[string]string `json:"images,omitempty"`
 }
 
 // ContextMeta is a composition of export context and meta data.
@@ -60,6 +88,8 @@ type ContextMeta struct {
 type Codelab struct {
 	Meta
 	Steps []*Step
+	// Extra contains metadata the parser did not recognize, keyed by its name.
+	Extra map[string]string
 }
 
 // NewStep creates a new codelab step, adding it to c.Steps slice.
@@ -61,3 +61,33 @@ func TestLegacyStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeID(t *testing.T) {
+	tests := []struct {
+		in, out string
+		ok      bool
+	}{
+		{"", "", true},
+		{"my-codelab", "my-codelab", true},
+		{" My_Codelab.v2 ", "My_Codelab.v2", true},
+		{"my  codelab\tid", "my-codelab-id", true},
+		{"../../etc", "", false},
+		{"a/b", "", false},
+		{`a\b`, "", false},
+		{"..", "", false},
+		{".hidden", "", false},
+		{"-dash", "", false},
+		{"what?", "", false},
+		{"http://example.com/lab", "", false},
+	}
+	for i, test := range tests {
+		out, err := NormalizeID(test.in)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%d: NormalizeID(%q) err = %v; want ok %v", i, test.in, err, test.ok)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("%d: NormalizeID(%q) = %q; want %q", i, test.in, out, test.out)
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package types
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 )
@@ -63,6 +64,40 @@ type Node interface {
 	Env() []string
 	// MutateEnv replaces current node environment tags with env.
 	MutateEnv(env []string)
+	// Pos returns the node position in its source.
+	Pos() Pos
+	// MutatePos updates the node source position.
+	MutatePos(Pos)
+}
+
+// Pos is an optional position of a node in its source.
+// Sources with lines, such as Markdown, use Line and Column.
+// Those without, such as Google Docs, use Elem instead.
+// A position is unknown unless either Line or Elem is set, see IsValid.
+type Pos struct {
+	Line   int `json:"line,omitempty"`   // 1-based line number, zero if unknown
+	Column int `json:"column,omitempty"` // 1-based column number within Line, zero if unknown
+	Elem   int `json:"elem,omitempty"`   // 1-based index of the top-level source element, zero if unknown
+}
+
+// IsValid returns true if p is a known position: a line, possibly with
+// a column, or an element. A Column without Line is not a position.
+func (p Pos) IsValid() bool {
+	return p.Line > 0 || p.Elem > 0
+}
+
+// String returns p as "line:column", "line" or "element N", or an empty string
+// if p is not valid.
+func (p Pos) String() string {
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	case p.Line > 0:
+		return fmt.Sprintf("%d", p.Line)
+	case p.Elem > 0:
+		return fmt.Sprintf("element %d", p.Elem)
+	}
+	return ""
 }
 
 // IsItemsList returns true if t is one of ItemsListNode types.
@@ -94,6 +129,7 @@ type node struct {
 	typ   NodeType
 	block interface{}
 	env   []string
+	pos   Pos
 }
 
 func (b *node) Type() NodeType {
@@ -124,6 +160,14 @@ func (b *node) MutateEnv(e []string) {
 	sort.Strings(b.env)
 }
 
+func (b *node) Pos() Pos {
+	return b.pos
+}
+
+func (b *node) MutatePos(p Pos) {
+	b.pos = p
+}
+
 // NewListNode creates a new Node of type NodeList.
 func NewListNode(nodes ...Node) *ListNode {
 	n := &ListNode{node: node{typ: NodeList}}
@@ -353,6 +397,7 @@ func NewImageNode(src string) *ImageNode {
 type ImageNode struct {
 	node
 	Src      string
+	Alt      string // alternative text
 	MaxWidth float32
 }
 
@@ -0,0 +1,39 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import "testing"
+
+func TestPos(t *testing.T) {
+	tests := []struct {
+		pos   Pos
+		valid bool
+		str   string
+	}{
+		{Pos{}, false, ""},
+		{Pos{Column: 3}, false, ""},
+		{Pos{Line: 2}, true, "2"},
+		{Pos{Line: 2, Column: 3}, true, "2:3"},
+		{Pos{Elem: 4}, true, "element 4"},
+	}
+	for i, test := range tests {
+		if v := test.pos.IsValid(); v != test.valid {
+			t.Errorf("%d: %+v.IsValid() = %v; want %v", i, test.pos, v, test.valid)
+		}
+		if s := test.pos.String(); s != test.str {
+			t.Errorf("%d: %+v.String() = %q; want %q", i, test.pos, s, test.str)
+		}
+	}
+}
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -24,6 +26,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/googlecodelabs/tools/claat/types"
@@ -42,41 +45,337 @@ func cmdUpdate() {
 	if len(dirs) == 0 {
 		fatalf("no codelabs found in %s", strings.Join(roots, ", "))
 	}
-
-	type result struct {
-		dir  string
-		meta *types.Meta
-		err  error
-	}
-	ch := make(chan *result, len(dirs))
+	groups, errs := groupVariants(dirs)
 	for _, d := range dirs {
-		go func(d string) {
+		if err, ok := errs[d]; ok {
+			errorf(reportErr, d, err)
+		}
+	}
+
+	// fetch and parse all sources before writing any of them,
+	// so that codelabs moving to the same dir are detected
+	// regardless of the order in which they are fetched
+	opt := updateOptions{force: *force, dryRun: *dryRun, redirect: *redirect}
+	updates := make([]*sourceUpdate, len(groups))
+	var wg sync.WaitGroup
+	for i, g := range groups {
+		wg.Add(1)
+		go func(i int, g []*variant) {
+			defer wg.Done()
 			// random sleep up to 1 sec
 			// to reduce number of rate limit errors
 			time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
-			meta, err := updateCodelab(d)
-			ch <- &result{d, meta, err}
-		}(d)
+			updates[i] = prepareUpdate(g, opt)
+		}(i, g)
 	}
-	for _ = range dirs {
-		res := <-ch
-		if res.err != nil {
-			errorf(reportErr, res.dir, res.err)
-		} else {
-			printf(reportOk, res.meta.ID)
+	wg.Wait()
+
+	srcDirs := make(map[string][]string)
+	for _, u := range updates {
+		if u != nil {
+			srcDirs[u.src] = u.dirs()
+		}
+	}
+	dups := duplicateDirs(srcDirs)
+	for _, u := range updates {
+		if u == nil {
+			continue
+		}
+		if err, ok := dups[u.src]; ok {
+			u.fail(err)
+			continue
+		}
+		wg.Add(1)
+		go func(u *sourceUpdate) {
+			defer wg.Done()
+			u.apply(opt)
+		}(u)
+	}
+	wg.Wait()
+
+	// redirect maps are shared by codelabs of the same base dir,
+	// so they are recorded here, one codelab at a time
+	for _, g := range groups {
+		for _, v := range g {
+			if v.err == nil && v.res.redirects != nil {
+				v.err = addRedirects(v.res.redirects)
+			}
+			switch {
+			case v.err != nil:
+				errorf(reportErr, v.dir, v.err)
+			case !v.res.updated:
+				printf(reportSkip, v.res.meta.ID)
+			case v.res.plan != nil:
+				printf("%s", v.res.plan)
+			default:
+				printf(reportOk, v.res.meta.ID)
+			}
 		}
 	}
 }
 
+// updateOptions are options of updateCodelab, usually set with cli flags.
+type updateOptions struct {
+	force    bool // update even if the source has not changed
+	dryRun   bool // report an updatePlan instead of making changes
+	redirect bool // redirect from the old dir if codelab ID has changed
+}
+
+// updateResult is the outcome of updateCodelab.
+type updateResult struct {
+	meta    *types.ContextMeta // codelab metadata, as stored after the update
+	updated bool               // false if the codelab has not changed
+	plan    *updatePlan        // changes a dry run would have made
+	// redirects from the old dir of a moved codelab, not yet recorded;
+	// see writeRedirects
+	redirects *redirectMap
+}
+
+// variant is a codelab dir, exported for one of the environments of its source,
+// and the outcome of updating it with updateVariants.
+type variant struct {
+	dir  string
+	meta *types.ContextMeta // stored metadata
+	res  *updateResult
+	err  error
+
+	// set by prepareUpdate if the variant needs to be updated
+	basedir string // base dir of the variant dirs
+	old     string // current codelab dir
+	newdir  string // codelab dir after the update
+}
+
+// groupVariants reads metadata of codelab dirs and groups them by their source,
+// so that each source is fetched only once. Dirs whose metadata cannot be read
+// are returned in errs instead.
+func groupVariants(dirs []string) (groups [][]*variant, errs map[string]error) {
+	errs = make(map[string]error)
+	index := make(map[string]int) // source => groups index
+	for _, d := range dirs {
+		meta, err := readMeta(filepath.Join(d, metaFilename))
+		if err != nil {
+			errs[d] = err
+			continue
+		}
+		i, ok := index[meta.Source]
+		if !ok {
+			i = len(groups)
+			index[meta.Source] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], &variant{dir: d, meta: meta})
+	}
+	return groups, errs
+}
+
 // updateCodelab reads metadata from a dir/codelab.json file,
 // re-exports the codelab just like it normally would in exportCodelab,
 // and removes assets (images) which are not longer in use.
-func updateCodelab(dir string) (*types.Meta, error) {
+//
+// Unless opt.force is true, the codelab is left as is if its source
+// has not changed since the last update or export, and the returned
+// updated value is false. See sourceChanged for details.
+//
+// If opt.dryRun is true, nothing is written to or removed from dir
+// and its ancestors. Instead, the codelab is exported into a temporary dir,
+// which is then compared with the existing files to make an updatePlan.
+//
+// If the codelab ID has changed, its original dir is removed or,
+// if opt.redirect is true, replaced with redirects. See writeRedirects.
+// The redirects are recorded in the redirect map before updateCodelab returns.
+func updateCodelab(dir string, opt updateOptions) (*updateResult, error) {
 	// get stored codelab metadata and fail early if we can't
 	meta, err := readMeta(filepath.Join(dir, metaFilename))
 	if err != nil {
 		return nil, err
 	}
+	v := &variant{dir: dir, meta: meta}
+	updateVariants([]*variant{v}, opt)
+	if v.err == nil && v.res.redirects != nil {
+		v.err = addRedirects(v.res.redirects)
+	}
+	return v.res, v.err
+}
+
+// updateVariants updates codelab dirs of variants vs, all of the same source,
+// the way updateCodelab does. The source is fetched and parsed, and its images
+// are downloaded, only once. The variants which have changed are all written
+// to staging dirs first, and none of them is committed unless all have been
+// written successfully.
+//
+// The outcome of each variant is recorded in its res and err fields.
+func updateVariants(vs []*variant, opt updateOptions) {
+	if u := prepareUpdate(vs, opt); u != nil {
+		u.apply(opt)
+	}
+}
+
+// sourceUpdate is a codelab source fetched and parsed by prepareUpdate,
+// along with its variants which need to be updated.
+type sourceUpdate struct {
+	src     string
+	clab    *codelab
+	hash    string
+	lastmod types.ContextTime
+	vs      []*variant
+}
+
+// prepareUpdate fetches and parses the source of variants vs, unless it has
+// not changed, and determines which of vs need to be updated, and their new dirs.
+// The other variants get their outcome recorded. It returns nil if none of vs
+// needs to be updated.
+func prepareUpdate(vs []*variant, opt updateOptions) *sourceUpdate {
+	fail := func(vs []*variant, err error) *sourceUpdate {
+		for _, v := range vs {
+			v.err = err
+		}
+		return nil
+	}
+	src := vs[0].meta.Source
+	if !opt.force {
+		mod := fetchModTime(src)
+		var changed []*variant
+		for _, v := range vs {
+			if overridesStored(v.meta) && !sourceChanged(v.meta, mod) {
+				v.res = &updateResult{meta: v.meta}
+				continue
+			}
+			changed = append(changed, v)
+		}
+		if vs = changed; len(vs) == 0 {
+			return nil
+		}
+	}
+
+	// fetch and parse codelab source
+	clab, err := slurpCodelab(src)
+	if err != nil {
+		return fail(vs, err)
+	}
+	hash, err := codelabHash(clab.Codelab)
+	if err != nil {
+		return fail(vs, err)
+	}
+	lastmod := types.ContextTime(clab.mod)
+	var todo []*variant
+	for _, v := range vs {
+		if !opt.force && hash == v.meta.Hash && overridesStored(v.meta) {
+			// only the modification time has changed; store it
+			// so that the source is not fetched again next time
+			v.meta.Updated = &lastmod
+			v.res = &updateResult{meta: v.meta}
+			if !opt.dryRun {
+				v.err = writeMeta(filepath.Join(v.dir, metaFilename), v.meta)
+			}
+			continue
+		}
+		todo = append(todo, v)
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	for _, v := range todo {
+		meta := v.meta
+		v.basedir = variantBase(v.dir, meta.EnvDir)
+		if v.newdir, err = variantDir(v.basedir, &clab.Meta, meta.Env, meta.EnvDir); err != nil {
+			return fail(todo, err)
+		}
+		if v.old, err = variantDir(v.basedir, &meta.Meta, meta.Env, meta.EnvDir); err != nil {
+			return fail(todo, err)
+		}
+	}
+	return &sourceUpdate{src: src, clab: clab, hash: hash, lastmod: lastmod, vs: todo}
+}
+
+// dirs returns codelab dirs of u variants after the update.
+func (u *sourceUpdate) dirs() []string {
+	dirs := make([]string, len(u.vs))
+	for i, v := range u.vs {
+		dirs[i] = v.newdir
+	}
+	return dirs
+}
+
+// fail records err as the outcome of all u variants.
+func (u *sourceUpdate) fail(err error) {
+	for _, v := range u.vs {
+		v.err = err
+	}
+}
+
+// apply writes u variants, downloading images once, and commits them
+// if all have been written successfully. See updateVariants.
+func (u *sourceUpdate) apply(opt updateOptions) {
+	clab, src, todo := u.clab, u.src, u.vs
+	var err error
+
+	// slurp codelab assets once and rewrite image URLs,
+	// unless this is a dry run
+	var imgdir string
+	var imgmap map[string]string
+	if !opt.dryRun {
+		var client *http.Client
+		if clab.typ == srcGoogleDoc {
+			if client, err = driveClient(); err != nil {
+				u.fail(err)
+				return
+			}
+		}
+		if imgdir, err = ioutil.TempDir("", "claat-images"); err != nil {
+			u.fail(err)
+			return
+		}
+		defer os.RemoveAll(imgdir)
+		if imgmap, err = slurpImages(client, src, imgdir, clab.Steps); err != nil {
+			u.fail(err)
+			return
+		}
+	}
+
+	var pending []*pendingUpdate
+	defer func() {
+		// no-op for committed dirs
+		for _, p := range pending {
+			os.RemoveAll(p.staged)
+		}
+	}()
+	for _, v := range todo {
+		p, err := stageVariant(v, clab, u.hash, u.lastmod, imgdir, imgmap, opt)
+		if err != nil {
+			v.err = err
+			for _, o := range todo {
+				if o.err == nil {
+					o.res, o.err = nil, fmt.Errorf("not updated: %s failed", v.dir)
+				}
+			}
+			return
+		}
+		if p != nil {
+			pending = append(pending, p)
+		}
+	}
+	for _, p := range pending {
+		p.v.err = p.commit(opt.redirect)
+	}
+}
+
+// pendingUpdate is a codelab variant written to a staging dir, not yet committed.
+type pendingUpdate struct {
+	v      *variant
+	staged string // staging dir of v.newdir
+}
+
+// stageVariant writes codelab clab, the new content of variant v, to a staging dir,
+// copying images of imgmap from imgdir, as downloaded by slurpImages.
+// It sets v.res, and returns the pending update of v, or nil on a dry run.
+// Images are not downloaded on a dry run, so imgdir and imgmap are empty.
+func stageVariant(v *variant, clab *codelab, hash string, lastmod types.ContextTime, imgdir string, imgmap map[string]string, opt updateOptions) (*pendingUpdate, error) {
+	meta := v.meta
+	meta.Updated = &lastmod
+	meta.Hash = hash
+	meta.Imports = importURLs(clab.Steps)
+	stored := meta.Images
+	meta.Images = imgmap
 	// override allowed options from cli
 	if *prefix != "" {
 		meta.Prefix = *prefix
@@ -85,56 +384,306 @@ func updateCodelab(dir string) (*types.Meta, error) {
 		meta.MainGA = *globalGA
 	}
 
-	// fetch and parse codelab source
-	clab, err := slurpCodelab(meta.Source)
-	if err != nil {
-		return nil, err
+	old, newdir := v.old, v.newdir
+	if old != newdir {
+		// don't let a changed ID take over another codelab's dir
+		other, err := readMeta(filepath.Join(newdir, metaFilename))
+		if err == nil && other.Source != meta.Source {
+			return nil, fmt.Errorf("cannot move to %s: the dir belongs to codelab %s", newdir, other.Source)
+		}
 	}
-	updated := types.ContextTime(clab.mod)
-	meta.Context.Updated = &updated
-
-	basedir := filepath.Join(dir, "..")
-	newdir := codelabDir(basedir, &clab.Meta)
-	imgdir := filepath.Join(newdir, imgDirname)
 
-	// slurp codelab assets to disk and rewrite image URLs
-	var client *http.Client
-	if clab.typ == srcGoogleDoc {
-		client, err = driveClient()
+	oldID := meta.ID
+	meta.Meta = clab.Meta
+	v.res = &updateResult{meta: meta, updated: true}
+	if opt.dryRun {
+		outdir, err := ioutil.TempDir("", "claat-update")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(outdir)
+		if err := writeCodelab(outdir, clab.Codelab, &meta.Context); err != nil {
+			return nil, err
+		}
+		p, err := planUpdate(outdir, old, newdir, opt.redirect)
 		if err != nil {
 			return nil, err
 		}
+		if err := p.planImages(imageURLs(clab.Steps), stored); err != nil {
+			return nil, err
+		}
+		p.ID, p.NewID = oldID, meta.ID
+		v.res.plan = p
+		return nil, nil
 	}
-	imgmap, err := slurpImages(client, meta.Source, imgdir, clab.Steps)
+
+	// newdir is replaced only if the whole codelab has been written successfully;
+	// the images dir is replaced entirely, which removes unused images
+	staged, err := stageDir(newdir)
 	if err != nil {
 		return nil, err
 	}
-
-	// write codelab and its metadata
-	if err := writeCodelab(newdir, clab.Codelab, &meta.Context); err != nil {
+	write := func() error {
+		if err := copyImages(imgdir, filepath.Join(staged, imgDirname), imgmap); err != nil {
+			return err
+		}
+		// write codelab and its metadata
+		if err := writeCodelab(staged, clab.Codelab, &meta.Context); err != nil {
+			return err
+		}
+		return carryOver(staged, newdir)
+	}
+	if err := write(); err != nil {
+		os.RemoveAll(staged)
 		return nil, err
 	}
+	return &pendingUpdate{v: v, staged: staged}, nil
+}
 
-	// cleanup:
-	// - remove original dir if codelab ID has changed and so has the output dir
-	// - otherwise, remove images which are not in imgs
-	old := codelabDir(basedir, &meta.Meta)
-	if old != newdir {
-		return &meta.Meta, os.RemoveAll(old)
+// commit replaces the codelab dir with the staging dir of p.
+// If the codelab ID has changed, and so has the output dir, the original dir
+// is removed or, if redirect is true, replaced with redirects, which are
+// stored in the update result for the caller to record.
+func (p *pendingUpdate) commit(redirect bool) error {
+	v := p.v
+	if err := commitDir(p.staged, v.newdir); err != nil {
+		return err
 	}
-	visit := func(p string, fi os.FileInfo, err error) error {
-		if err != nil || p == imgdir {
+	if v.old == v.newdir {
+		return nil
+	}
+	if redirect {
+		var err error
+		v.res.redirects, err = writeRedirects(v.basedir, v.old, v.newdir)
+		return err
+	}
+	return os.RemoveAll(v.old)
+}
+
+// updatePlan describes changes an update would make to the output tree.
+type updatePlan struct {
+	ID, NewID  string   // codelab ID before and after the update
+	Dir        string   // current codelab dir
+	NewDir     string   // codelab dir after the update
+	Create     []string // files which would be created
+	Overwrite  []string // existing files which would be overwritten
+	Download   []string // image URLs which would be downloaded
+	Remove     []string // images which would be removed
+	RemoveDirs []string // directories which would be removed entirely
+	Redirects  []string // directories which would be replaced with redirects
+	Stubs      []string // redirect stub pages which would be written
+}
+
+// planUpdate compares codelab files exported into staged dir,
+// images excluded, with the existing codelab dir, which would be replaced
+// by newdir. See planImages for images.
+// If redirect is true, a dir different from newdir would be replaced
+// with redirects instead of being removed.
+func planUpdate(staged, dir, newdir string, redirect bool) (*updatePlan, error) {
+	p := &updatePlan{Dir: dir, NewDir: newdir}
+	err := filepath.Walk(staged, func(sp string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
 			return err
 		}
-		if fi.IsDir() {
-			return filepath.SkipDir
+		rel, err := filepath.Rel(staged, sp)
+		if err != nil {
+			return err
 		}
-		if _, ok := imgmap[filepath.Base(p)]; !ok {
-			return os.Remove(p)
+		dst := filepath.Join(newdir, rel)
+		if _, err := os.Stat(dst); err == nil {
+			p.Overwrite = append(p.Overwrite, dst)
+		} else if os.IsNotExist(err) {
+			p.Create = append(p.Create, dst)
+		} else {
+			return err
 		}
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return &meta.Meta, filepath.Walk(imgdir, visit)
+	if dir != newdir {
+		if _, err := os.Stat(dir); err != nil {
+			return p, nil
+		}
+		var pages []string
+		if redirect {
+			if pages, err = redirectPages(dir); err != nil {
+				return nil, err
+			}
+		}
+		// without pages, there is nothing to redirect from
+		if len(pages) == 0 {
+			p.RemoveDirs = append(p.RemoveDirs, dir)
+			return p, nil
+		}
+		p.Redirects = append(p.Redirects, dir)
+		for _, rel := range pages {
+			p.Stubs = append(p.Stubs, filepath.Join(dir, rel))
+		}
+	}
+	return p, nil
+}
+
+// planImages adds images of urls, used by the updated codelab, to plan p
+// without downloading them. Instead, urls are compared with stored,
+// the image files of p.Dir mapped to the URLs they were downloaded from,
+// as recorded in codelab metadata.
+//
+// An image of a known URL is assumed to be unchanged and copied
+// to p.NewDir, while the others would be downloaded. If the dir does not
+// change, its images which are no longer used would be removed.
+func (p *updatePlan) planImages(urls []string, stored map[string]string) error {
+	files := make(map[string]string, len(stored)) // url => file
+	for file, u := range stored {
+		files[u] = file
+	}
+	used := make(map[string]bool)
+	for _, u := range urls {
+		file, ok := files[u]
+		if !ok {
+			p.Download = append(p.Download, u)
+			continue
+		}
+		used[file] = true
+		dst := filepath.Join(p.NewDir, imgDirname, file)
+		if _, err := os.Stat(dst); err == nil {
+			p.Overwrite = append(p.Overwrite, dst)
+		} else if os.IsNotExist(err) {
+			p.Create = append(p.Create, dst)
+		} else {
+			return err
+		}
+	}
+	if p.Dir != p.NewDir {
+		return nil
+	}
+	imgs, err := ioutil.ReadDir(filepath.Join(p.Dir, imgDirname))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fi := range imgs {
+		if !fi.IsDir() && !used[fi.Name()] {
+			p.Remove = append(p.Remove, filepath.Join(p.Dir, imgDirname, fi.Name()))
+		}
+	}
+	return nil
+}
+
+// String formats p as a multi-line report, starting with "plan" and the codelab ID.
+func (p *updatePlan) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "plan\t%s", p.ID)
+	if p.NewID != p.ID {
+		fmt.Fprintf(&buf, "\n\tid\t%s => %s", p.ID, p.NewID)
+	}
+	if p.NewDir != p.Dir {
+		fmt.Fprintf(&buf, "\n\tdir\t%s => %s", p.Dir, p.NewDir)
+	}
+	for _, l := range []struct {
+		verb  string
+		paths []string
+	}{
+		{"create", p.Create},
+		{"overwrite", p.Overwrite},
+		{"download", p.Download},
+		{"remove", p.Remove},
+		{"removedir", p.RemoveDirs},
+		{"redirect", p.Redirects},
+		{"stub", p.Stubs},
+	} {
+		for _, path := range l.paths {
+			fmt.Fprintf(&buf, "\n\t%s\t%s", l.verb, path)
+		}
+	}
+	return buf.String()
+}
+
+// sourceChanged reports whether codelab source of stored metadata meta
+// may have changed, given its current modification time mod.
+//
+// The source is considered unchanged only if mod matches meta.Updated
+// and the codelab has no imports, since imported fragments may change
+// independently. Otherwise, the caller is expected to fetch the source
+// and compare its codelabHash with meta.Hash.
+func sourceChanged(meta *types.ContextMeta, mod time.Time) bool {
+	if mod.IsZero() || meta.Updated == nil || len(meta.Imports) > 0 {
+		return true
+	}
+	// codelab.json timestamps have a precision of one second
+	updated := time.Time(*meta.Updated).Truncate(time.Second)
+	return !mod.Truncate(time.Second).Equal(updated)
+}
+
+// overridesStored reports whether options update overrides from cli,
+// -prefix and -ga, are either not set or already stored in meta.
+// Otherwise, the codelab needs to be updated even if its source has not changed.
+func overridesStored(meta *types.ContextMeta) bool {
+	return (*prefix == "" || *prefix == meta.Prefix) && (*globalGA == "" || *globalGA == meta.MainGA)
+}
+
+// codelabHash returns a hash of parsed codelab content, including
+// imported fragments and metadata. It must be computed before
+// image URLs are rewritten with slurpImages.
+//
+// Source positions of nodes are not part of the hash, so that
+// moving content around without changing it, e.g. by adding blank
+// lines, does not result in an update.
+func codelabHash(clab *types.Codelab) (string, error) {
+	b, err := json.Marshal(clab)
+	if err != nil {
+		return "", err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "", err
+	}
+	if b, err = json.Marshal(stripPos(v)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(b)), nil
+}
+
+// stripPos removes "pos" keys of node positions from v, a decoded JSON value, recursively.
+func stripPos(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "pos")
+		for k, e := range v {
+			v[k] = stripPos(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = stripPos(e)
+		}
+	}
+	return v
+}
+
+// imageURLs returns URLs of images in steps, de-duped.
+func imageURLs(steps []*types.Step) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, st := range steps {
+		for _, n := range imageNodes(st.Content.Nodes) {
+			if !seen[n.Src] {
+				seen[n.Src] = true
+				urls = append(urls, n.Src)
+			}
+		}
+	}
+	return urls
+}
+
+// importURLs returns URLs of fragments imported in steps, de-duped.
+func importURLs(steps []*types.Step) []string {
+	var urls []string
+	for _, st := range steps {
+		for _, n := range importNodes(st.Content.Nodes) {
+			urls = append(urls, n.URL)
+		}
+	}
+	return unique(urls)
 }
 
 // scanPaths looks for codelab metadata files in roots, recursively.
@@ -165,12 +714,19 @@ func scanPaths(roots []string) ([]string, error) {
 }
 
 // walkPath walks root dir recursively, looking for metaFilename files.
+// Staging directories, left behind by interrupted exports, are skipped.
 func walkPath(root string) ([]string, error) {
 	var dirs []string
 	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
-		if err != nil || fi.IsDir() {
+		if err != nil {
 			return err
 		}
+		if fi.IsDir() {
+			if p != root && isStageDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if filepath.Base(p) == metaFilename {
 			dirs = append(dirs, filepath.Dir(p))
 		}
@@ -0,0 +1,304 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "lab.md")
+	md := "id: lab\n\n# Lab\n\n## Step\n\nText.\n"
+	if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(o, e, f, p string) { *output, *expenv, *tmplout, *prefix = o, e, f, p }(*output, *expenv, *tmplout, *prefix)
+	*output = filepath.Join(dir, "out")
+	*expenv = "web"
+	*tmplout = "md"
+	*prefix = ""
+	if _, err := exportCodelab(src); err != nil {
+		t.Fatal(err)
+	}
+	labdir := filepath.Join(*output, "lab")
+
+	mod := time.Now().Add(time.Hour)
+	steps := []struct {
+		name    string
+		change  func() error
+		force   bool
+		updated bool
+	}{
+		{"same source", nil, false, false},
+		{"forced", nil, true, true},
+		{"touched", func() error { return os.Chtimes(src, mod, mod) }, false, false},
+		{"moved", func() error {
+			// same content at other positions, with a distinct modification time
+			if err := ioutil.WriteFile(src, []byte(strings.Replace(md, "Text.", "\nText.", 1)), 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(src, mod.Add(time.Hour), mod.Add(time.Hour))
+		}, false, false},
+		{"modified", func() error { return ioutil.WriteFile(src, []byte(md+"\nMore.\n"), 0644) }, false, true},
+		{"prefix", func() error { *prefix = "https://example.com/"; return nil }, false, true},
+		{"same prefix", nil, false, false},
+	}
+	for _, step := range steps {
+		if step.change != nil {
+			if err := step.change(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res, err := updateCodelab(labdir, updateOptions{force: step.force})
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.updated != step.updated {
+			t.Errorf("%s: updated = %v; want %v", step.name, res.updated, step.updated)
+		}
+		fi, err := os.Stat(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sourceChanged(res.meta, fi.ModTime()) {
+			t.Errorf("%s: sourceChanged is true after update", step.name)
+		}
+	}
+}
+
+func TestUpdateDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "lab.md")
+	md := "id: lab\n\n# Lab\n\n## Step\n\n![a](a.png)\n"
+	if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = filepath.Join(dir, "out")
+	*expenv = "web"
+	*tmplout = "md"
+	if _, err := exportCodelab(src); err != nil {
+		t.Fatal(err)
+	}
+	labdir := filepath.Join(*output, "lab")
+	meta, err := readMeta(filepath.Join(labdir, metaFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img string
+	for file, u := range meta.Images {
+		if u == "a.png" {
+			img = file
+		}
+	}
+	if len(meta.Images) != 1 || img == "" {
+		t.Fatalf("meta.Images = %v; want a.png only", meta.Images)
+	}
+	stale := filepath.Join(labdir, imgDirname, "stale.png")
+	if err := ioutil.WriteFile(stale, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// same ID: files are overwritten and unused images removed
+	res, err := updateCodelab(labdir, updateOptions{force: true, dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := res.plan
+	want := &updatePlan{
+		ID:        "lab",
+		NewID:     "lab",
+		Dir:       labdir,
+		NewDir:    labdir,
+		Overwrite: []string{filepath.Join(labdir, metaFilename), filepath.Join(labdir, "index.md"), filepath.Join(labdir, imgDirname, img)},
+		Remove:    []string{stale},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("plan = %+v; want %+v", p, want)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("dry run removed %s: %v", stale, err)
+	}
+
+	// new ID: the new dir is created and the old one removed;
+	// the new image is unreachable but a dry run does not download it
+	remote := "http://127.0.0.1:1/b.png"
+	md = strings.Replace(md, "id: lab", "id: lab2", 1) + "\n![b](" + remote + ")\n"
+	if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Now().Add(time.Hour)
+	if err := os.Chtimes(src, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	res, err = updateCodelab(labdir, updateOptions{dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newdir := filepath.Join(*output, "lab2")
+	want = &updatePlan{
+		ID:         "lab",
+		NewID:      "lab2",
+		Dir:        labdir,
+		NewDir:     newdir,
+		Create:     []string{filepath.Join(newdir, metaFilename), filepath.Join(newdir, "index.md"), filepath.Join(newdir, imgDirname, img)},
+		Download:   []string{remote},
+		RemoveDirs: []string{labdir},
+	}
+	if !reflect.DeepEqual(res.plan, want) {
+		t.Errorf("plan = %+v; want %+v", res.plan, want)
+	}
+	if _, err := os.Stat(newdir); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s: %v", newdir, err)
+	}
+	meta, err = readMeta(filepath.Join(labdir, metaFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "lab" {
+		t.Errorf("dry run modified %s: ID = %q", metaFilename, meta.ID)
+	}
+
+	// new ID with redirects: HTML pages of the old dir are replaced with stubs
+	page := filepath.Join(labdir, "index.html")
+	if err := ioutil.WriteFile(page, []byte("<html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = updateCodelab(labdir, updateOptions{dryRun: true, redirect: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.RemoveDirs = nil
+	want.Redirects = []string{labdir}
+	want.Stubs = []string{page}
+	if !reflect.DeepEqual(res.plan, want) {
+		t.Errorf("plan = %+v; want %+v", res.plan, want)
+	}
+}
+
+func TestUpdateIDTakeover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var srcs []string
+	for _, id := range []string{"lab", "other"} {
+		src := filepath.Join(dir, id+".md")
+		md := "id: " + id + "\n\n# Lab\n\n## Step\n\nText.\n"
+		if err := ioutil.WriteFile(src, []byte(md), 0644); err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, src)
+	}
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = filepath.Join(dir, "out")
+	*expenv = ""
+	*tmplout = "md"
+	for _, src := range srcs {
+		if _, err := exportCodelab(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lab.md now has the ID of other.md; neither dir may be touched
+	md := "id: other\n\n# Lab\n\n## Step\n\nText.\n"
+	if err := ioutil.WriteFile(srcs[0], []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+	labdir := filepath.Join(*output, "lab")
+	_, err = updateCodelab(labdir, updateOptions{force: true})
+	if err == nil || !strings.Contains(err.Error(), "belongs to codelab") {
+		t.Errorf("updateCodelab(%s): %v; want the dir belongs to another codelab", labdir, err)
+	}
+	for i, id := range []string{"lab", "other"} {
+		meta, err := readMeta(filepath.Join(*output, id, metaFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Source != srcs[i] {
+			t.Errorf("%s: meta.Source = %q; want %q", id, meta.Source, srcs[i])
+		}
+	}
+}
+
+func TestUpdateVariantsFetchOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claat-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var mu sync.Mutex
+	var fetches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		io.WriteString(w, "id: lab\n\n# Lab\n\n## Step\n\n[[environment web]]\n\nWeb only.\n")
+	}))
+	defer ts.Close()
+
+	defer func(o, e, f string) { *output, *expenv, *tmplout = o, e, f }(*output, *expenv, *tmplout)
+	*output = filepath.Join(dir, "out")
+	*expenv = "web,kiosk"
+	*tmplout = "md"
+	if _, err := exportCodelab(ts.URL + "/lab.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	dirs, err := scanPaths([]string{*output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, errs := groupVariants(dirs)
+	if len(errs) != 0 || len(groups) != 1 || len(groups[0]) != 2 {
+		t.Fatalf("groupVariants(%v) = %v, %v; want one group of 2 variants", dirs, groups, errs)
+	}
+	fetches = 0
+	updateVariants(groups[0], updateOptions{force: true})
+	for _, v := range groups[0] {
+		if v.err != nil || !v.res.updated {
+			t.Errorf("%s: res = %+v, err = %v; want updated", v.dir, v.res, v.err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("source fetched %d times; want 1", fetches)
+	}
+}